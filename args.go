@@ -1,29 +1,42 @@
 package forge
 
 import (
+	"fmt"
 	"github.com/SekyrOrg/forge/openapi"
 	"github.com/google/uuid"
 	"github.com/projectdiscovery/goflags"
-	"log"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultOutputFolder is where the CLI writes the beacons unless --in-place is used
+const DefaultOutputFolder = "out"
+
+// Args holds the command line arguments of the forge CLI
 type Args struct {
-	CreatorUrl   string
-	FilePaths    []string
-	Verbose      bool
-	ConfigPath   string
-	OutputFolder string
-	BeaconOpts   beaconOptions
+	Options
+	FilePaths  []string
+	Commands   []string
+	Inputs     InputOptions
+	Verbose    bool
+	ConfigPath string
+	DryRun     bool
+	JSON       bool
+	InPlace    bool
+	Yes        bool
 }
 
-func ParseCLIArguments() *Args {
+// ParseCLIArguments parses the command line arguments and merges the configuration file if provided
+func ParseCLIArguments() (*Args, error) {
 	var args Args
+	args.CacheMaxSize = DefaultCacheMaxSize
 	flagSet := goflags.NewFlagSet()
 	flagSet.SetDescription(`Forge is a tool for generating beacons for the Sekyr platform.
-Create a beacon by overwriting the original executable
-Example: ./forge -f /path/to/executable 
+Create a beacon in the "out" folder, the original executable is left untouched
+Example: ./forge -f /path/to/executable
 
 Create a beacon using a specific group id
 Example: ./forge -f /path/to/executable -id 46158A7C-B777-43AC-8798-0CF619C4EB04
@@ -31,57 +44,221 @@ Example: ./forge -f /path/to/executable -id 46158A7C-B777-43AC-8798-0CF619C4EB04
 Create a beacon and save it to a specific folder
 Example: ./forge -f /path/to/executable -o /path/to/folder
 
+Create a beacon by overwriting the original executable, after confirming or with --yes
+Example: ./forge -f /path/to/executable --in-place --yes
+
 Create a beacon using a configuration file
 Example: ./forge -C /path/to/config.yaml
 `)
 
 	flagSet.CreateGroup("Forge Options", "Forge Options",
 		flagSet.StringVarP(&args.CreatorUrl, "gateway-addr", "a", "https://gateway.sekyr.com", "Address of the gateway server"),
-		flagSet.StringSliceVarP((*goflags.StringSlice)(&args.FilePaths), "files", "f", []string{}, "Comma separated list of File path for binaries to be converted", goflags.StringSliceOptions),
+		flagSet.StringSliceVarP((*goflags.StringSlice)(&args.FilePaths), "files", "f", []string{}, "Comma separated list of File path, directories or glob patterns for binaries to be converted", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringSliceVar((*goflags.StringSlice)(&args.Commands), "commands", []string{}, "Comma separated list of command names resolved against $PATH, e.g. whoami,id,nc", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVar(&args.Root, "root", "", "Root filesystem the commands and the links of its files are resolved in, using the default PATH of the root"),
+		flagSet.BoolVarP(&args.Inputs.Recursive, "recursive", "R", false, "Include the files of subdirectories of directories given with -f"),
+		flagSet.IntVar(&args.Inputs.MaxDepth, "max-depth", 0, "Maximum depth of --recursive, 0 for unlimited"),
+		flagSet.StringSliceVar((*goflags.StringSlice)(&args.Inputs.Include), "include", []string{}, "Comma separated glob patterns, only files matching one of them are converted", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringSliceVar((*goflags.StringSlice)(&args.Inputs.Exclude), "exclude", []string{}, "Comma separated glob patterns, files matching one of them are not converted", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.BoolVar(&args.DryRun, "dry-run", false, "Print the plan of the run without uploading or changing any file"),
+		flagSet.BoolVar(&args.JSON, "json", false, "Print the --dry-run plan as JSON"),
 		flagSet.BoolVarP(&args.Verbose, "verbose", "v", false, "Enable verbose output for forge"),
-		flagSet.StringVarP(&args.OutputFolder, "output", "o", "out", "Output folder for the beacons. OBS! if not provided beacons are overwritten"),
+		flagSet.StringVarP(&args.OutputFolder, "output", "o", "", "Output folder for the beacons (default \""+DefaultOutputFolder+"\"), not allowed with --in-place"),
+		flagSet.StringSliceVar((*goflags.StringSlice)(&args.Preserve), "preserve", []string{}, "Comma separated attributes of the originals to preserve besides the mode: owner, timestamps, xattrs (capabilities, SELinux labels and ACLs) or all", goflags.CommaSeparatedStringSliceOptions),
+		flagSet.StringVar(&args.Layout, "layout", LayoutFlat, "Layout of the output folder: flat (file names only, collisions are errors) or tree (mirror the absolute source paths)"),
+		flagSet.BoolVar(&args.InPlace, "in-place", false, "Overwrite the original files with their beacons, the originals are kept in --backup-dir"),
+		flagSet.BoolVarP(&args.Yes, "yes", "y", false, "Do not ask for confirmation before overwriting files with --in-place"),
 		flagSet.StringVarP(&args.ConfigPath, "config", "C", "", "Path to a  configuration file"),
+		flagSet.IntVar(&args.Concurrency, "concurrency", 4, "Maximum number of files uploaded at the same time"),
+		flagSet.Var(&rateValue{&args.Rate}, "rate", "Maximum number of uploads per second, 0 for unlimited"),
+		flagSet.IntVar(&args.Retries, "retries", 3, "Number of times a failed upload is retried"),
+		flagSet.DurationVar(&args.RetryBackoff, "retry-backoff", DefaultRetryBackoff, "Delay before the first retry, doubled for every retry"),
+		flagSet.DurationVar(&args.RetryMaxBackoff, "retry-max-backoff", DefaultRetryMaxBackoff, "Maximum delay between retries"),
+		flagSet.StringVar(&args.ManifestPath, "manifest", "forge-manifest.json", "Path of the JSON manifest of the run, empty to disable"),
+		flagSet.StringVar(&args.PreviousManifest, "previous-manifest", "", "Manifest of a previous run, its beacons are skipped (defaults to --manifest if it exists)"),
+		flagSet.StringVar(&args.LinkPolicy, "links", LinkTarget, "How links are handled: target (convert the target once and keep the links), replace (standalone beacon per link) or skip"),
+		flagSet.BoolVar(&args.SmokeTest, "smoke-test", false, "Run beacons built for the host next to their original and abort their installation if the exit code or stdout differ"),
+		flagSet.StringSliceVar((*goflags.StringSlice)(&args.SmokeArgs), "smoke-args", []string{}, "Argument of the --smoke-test runs, repeat the flag for several arguments, commas are kept, e.g. --smoke-args --version", goflags.StringSliceOptions),
+		flagSet.DurationVar(&args.SmokeTimeout, "smoke-timeout", DefaultSmokeTimeout, "Maximum duration of every --smoke-test run"),
+		flagSet.BoolVar(&args.Force, "force", false, "Convert protected system files: dynamic loaders, libc, init, package managers and forge itself"),
+		flagSet.BoolVar(&args.ContinueOnError, "continue-on-error", false, "Process every file even if some of them fail, failures are listed in the summary"),
+		flagSet.StringVar(&args.BackupDir, "backup-dir", DefaultBackupDir(), "Folder where the originals of overwritten files are kept"),
+		flagSet.BoolVar(&args.NoBackup, "no-backup", false, "Do not keep the originals of overwritten files"),
+		flagSet.StringVar(&args.CacheDir, "cache-dir", DefaultCacheDir(), "Folder where beacons are cached"),
+		flagSet.BoolVar(&args.NoCache, "no-cache", false, "Do not use or fill the beacon cache"),
+		flagSet.DurationVar(&args.CacheTTL, "cache-ttl", DefaultCacheTTL, "How long cached beacons are used, 0 for no limit"),
+		flagSet.Var(&sizeValue{&args.CacheMaxSize}, "cache-max-size", "Size the cache is pruned to, e.g. 512M or 2G, 0 for no limit (default 1G)"),
+		flagSet.BoolVar(&args.SkipPreflight, "skip-preflight", false, "Do not check the targets against the distlist of the creator before uploading"),
 	)
+	flagSet.CreateGroup("Authentication Options", "Authentication", authFlags(flagSet, &args.Options)...)
 	flagSet.CreateGroup("Beacon Options", "Beacon Configuration",
 		flagSet.StringVarP(&args.BeaconOpts.GroupId, "group-id", "id", "", "Group ID for the beacon, if not provided the default UUID is used"),
 		flagSet.StringVarP(&args.BeaconOpts.ReportAddr, "reporter-addr", "r", "reporter.sekyr.com:53", "Address of the reporter server, used for DNS beacons"),
-		flagSet.StringVar(&args.BeaconOpts.Arch, "arch", runtime.GOARCH, "The architecture the beacon will run on"),
-		flagSet.StringVar(&args.BeaconOpts.Os, "os", runtime.GOOS, "The Operating System the beacon will run on"),
+		flagSet.StringVar(&args.BeaconOpts.Arch, "arch", runtime.GOARCH, "The architecture the beacon will run on, only used with --force-target"),
+		flagSet.StringVar(&args.BeaconOpts.Os, "os", runtime.GOOS, "The Operating System the beacon will run on, only used with --force-target"),
+		flagSet.BoolVar(&args.ForceTarget, "force-target", false, "Use --os and --arch for every file instead of detecting them from the file headers"),
 		flagSet.BoolVar(&args.BeaconOpts.Upx, "upx", false, "Upx the beacon (compression, not compatible with all transports)"),
 		flagSet.IntVar(&args.BeaconOpts.UpxLevel, "upx-level", 1, "Upx level for the beacon (level of compression)"),
 		flagSet.StringVar(&args.BeaconOpts.Transport, "transport", "dns", "Transport tag for the beacon [dns, http, icmp]"),
+		flagSet.BoolVar(&args.BeaconOpts.Gzip, "gzip", false, "Gzip compress the transfer of the binary and the beacon"),
 		flagSet.BoolVarP(&args.BeaconOpts.Debug, "debug", "D", false, "Enable debug output for the beacon"),
 	)
 	if err := flagSet.Parse(); err != nil {
-		log.Fatal("error parsing arguments: ", err)
+		return nil, fmt.Errorf("error parsing arguments: %w", err)
 	}
 	// default values, not configurable
 	args.BeaconOpts.Lldflags = "-s -w"
 	args.BeaconOpts.Static = true
 
-	mergeConfig(args, flagSet)
-	if len(args.FilePaths) == 0 {
-		log.Fatal("no files provided, use -f to provide a file paths, use , to separate multiple files")
+	if err := mergeConfig(args.ConfigPath, flagSet); err != nil {
+		return nil, err
+	}
+	if args.InPlace && args.OutputFolder != "" {
+		return nil, ErrInPlaceOutput
+	}
+	if args.Concurrency < 0 {
+		return nil, fmt.Errorf("%w, got %d", ErrInvalidConcurrency, args.Concurrency)
+	}
+	if !args.InPlace && args.OutputFolder == "" {
+		args.OutputFolder = DefaultOutputFolder
+	}
+	if len(args.FilePaths) == 0 && len(args.Commands) == 0 {
+		return nil, fmt.Errorf("%w, use -f to provide a file paths or --commands to provide command names, use , to separate multiple files", ErrNoFiles)
+	}
+
+	return &args, nil
+}
+
+// DistlistArgs holds the command line arguments of the distlist command
+type DistlistArgs struct {
+	Options
+	JSON       bool
+	ConfigPath string
+}
+
+// ParseDistlistArguments parses the command line arguments of the distlist command
+func ParseDistlistArguments() (*DistlistArgs, error) {
+	var args DistlistArgs
+	flagSet := goflags.NewFlagSet()
+	flagSet.SetDescription(`List the os and arch combinations supported by the creator
+Example: ./forge distlist -json
+`)
+	flagSet.CreateGroup("Distlist Options", "Distlist Options",
+		flagSet.StringVarP(&args.CreatorUrl, "gateway-addr", "a", "https://gateway.sekyr.com", "Address of the gateway server"),
+		flagSet.BoolVar(&args.JSON, "json", false, "Print the distlist as JSON"),
+		flagSet.StringVarP(&args.ConfigPath, "config", "C", "", "Path to a  configuration file"),
+	)
+	flagSet.CreateGroup("Authentication Options", "Authentication", authFlags(flagSet, &args.Options)...)
+	if err := flagSet.Parse(); err != nil {
+		return nil, fmt.Errorf("error parsing arguments: %w", err)
+	}
+	if err := mergeConfig(args.ConfigPath, flagSet); err != nil {
+		return nil, err
+	}
+	return &args, nil
+}
+
+// authFlags registers the flags of the credentials used to authenticate against the gateway
+func authFlags(flagSet *goflags.FlagSet, opts *Options) []*goflags.FlagData {
+	return []*goflags.FlagData{
+		flagSet.StringVar((*string)(&opts.Credentials.Token), "token", "", "Bearer token for the gateway (env "+TokenEnv+")"),
+		flagSet.StringVar((*string)(&opts.Credentials.APIKey), "api-key", "", "API key for the gateway (env "+APIKeyEnv+")"),
+		flagSet.StringVar(&opts.CredentialsPath, "credentials", "", "Path to a JSON credentials file with token and api_key (default ~/.forge/credentials.json)"),
 	}
+}
+
+// RestoreArgs holds the command line arguments of the restore command
+type RestoreArgs struct {
+	BackupDir  string
+	FilePaths  []string
+	All        bool
+	List       bool
+	ConfigPath string
+}
+
+// ParseRestoreArguments parses the command line arguments of the restore command
+func ParseRestoreArguments() (*RestoreArgs, error) {
+	var args RestoreArgs
+	flagSet := goflags.NewFlagSet()
+	flagSet.SetDescription(`Restore the originals of files overwritten by forge
+Example: ./forge restore -f /usr/bin/id,/usr/bin/whoami
 
-	return &args
+Restore every file in the backup store
+Example: ./forge restore -all
+`)
+	flagSet.CreateGroup("Restore Options", "Restore Options",
+		flagSet.StringSliceVarP((*goflags.StringSlice)(&args.FilePaths), "files", "f", []string{}, "Comma separated list of File path for binaries to be restored", goflags.StringSliceOptions),
+		flagSet.BoolVar(&args.All, "all", false, "Restore every file in the backup store"),
+		flagSet.BoolVarP(&args.List, "list", "l", false, "List the files in the backup store"),
+		flagSet.StringVar(&args.BackupDir, "backup-dir", DefaultBackupDir(), "Folder where the originals of overwritten files are kept"),
+		flagSet.StringVarP(&args.ConfigPath, "config", "C", "", "Path to a  configuration file"),
+	)
+	if err := flagSet.Parse(); err != nil {
+		return nil, fmt.Errorf("error parsing arguments: %w", err)
+	}
+	if err := mergeConfig(args.ConfigPath, flagSet); err != nil {
+		return nil, err
+	}
+	if !args.All && !args.List && len(args.FilePaths) == 0 {
+		return nil, fmt.Errorf("%w, use -f to select the files to restore or -all to restore every file", ErrNoFiles)
+	}
+	return &args, nil
 }
 
-func mergeConfig(args Args, flagSet *goflags.FlagSet) {
+// CacheArgs are the arguments of the cache ls and prune commands
+type CacheArgs struct {
+	CacheDir     string
+	CacheTTL     time.Duration
+	CacheMaxSize int64
+	JSON         bool
+	All          bool
+	ConfigPath   string
+}
+
+// ParseCacheArguments parses the command line arguments of the cache commands
+func ParseCacheArguments() (*CacheArgs, error) {
+	args := CacheArgs{CacheMaxSize: DefaultCacheMaxSize}
+	flagSet := goflags.NewFlagSet()
+	flagSet.SetDescription(`List or prune the cached beacons
+Example: ./forge cache ls -json
+
+Remove expired beacons and shrink the cache to its maximum size
+Example: ./forge cache prune -cache-max-size 256M
+`)
+	flagSet.CreateGroup("Cache Options", "Cache Options",
+		flagSet.StringVar(&args.CacheDir, "cache-dir", DefaultCacheDir(), "Folder where beacons are cached"),
+		flagSet.DurationVar(&args.CacheTTL, "cache-ttl", DefaultCacheTTL, "How long cached beacons are used, 0 for no limit"),
+		flagSet.Var(&sizeValue{&args.CacheMaxSize}, "cache-max-size", "Size the cache is pruned to, e.g. 512M or 2G, 0 for no limit (default 1G)"),
+		flagSet.BoolVar(&args.JSON, "json", false, "List the cached beacons as JSON"),
+		flagSet.BoolVar(&args.All, "all", false, "Prune every cached beacon"),
+		flagSet.StringVarP(&args.ConfigPath, "config", "C", "", "Path to a  configuration file"),
+	)
+	if err := flagSet.Parse(); err != nil {
+		return nil, fmt.Errorf("error parsing arguments: %w", err)
+	}
+	if err := mergeConfig(args.ConfigPath, flagSet); err != nil {
+		return nil, err
+	}
+	return &args, nil
+}
+
+func mergeConfig(configPath string, flagSet *goflags.FlagSet) error {
 	// merge config file
-	if args.ConfigPath == "" {
-		return
+	if configPath == "" {
+		return nil
 	}
 	// check if file exists
-	if _, err := os.Stat(args.ConfigPath); os.IsNotExist(err) {
-		log.Fatalln("error opening config file, does not exits: ", err)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return fmt.Errorf("error opening config file, does not exits: %w", err)
 	}
-	if err := flagSet.MergeConfigFile(args.ConfigPath); err != nil {
-		log.Fatalln("error merging config file: ", err)
+	if err := flagSet.MergeConfigFile(configPath); err != nil {
+		return fmt.Errorf("error merging config file: %w", err)
 	}
+	return nil
 }
 
-type beaconOptions struct {
+// BeaconOptions configures the beacon built by the creator
+type BeaconOptions struct {
 	ReportAddr string
 	Os         string
 	Arch       string
@@ -92,9 +269,10 @@ type beaconOptions struct {
 	Debug      bool
 	Lldflags   string
 	Transport  string
+	Gzip       bool
 }
 
-func (b *beaconOptions) toPostCreatorParams() *openapi.PostCreatorParams {
+func (b *BeaconOptions) toPostCreatorParams() (*openapi.PostCreatorParams, error) {
 	params := openapi.PostCreatorParams{
 		ReportAddr: b.ReportAddr,
 		Os:         b.Os,
@@ -103,7 +281,7 @@ func (b *beaconOptions) toPostCreatorParams() *openapi.PostCreatorParams {
 	if b.GroupId != "" {
 		groupId, err := uuid.Parse(b.GroupId)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("%w %q: %s", ErrInvalidGroupId, b.GroupId, err)
 		}
 		params.GroupUuid = &groupId
 	}
@@ -117,6 +295,9 @@ func (b *beaconOptions) toPostCreatorParams() *openapi.PostCreatorParams {
 		params.UpxLevel = &b.UpxLevel
 	}
 
+	if b.Gzip {
+		params.Gzip = &b.Gzip
+	}
 	if b.Debug {
 		params.Debug = &b.Debug
 	}
@@ -126,5 +307,71 @@ func (b *beaconOptions) toPostCreatorParams() *openapi.PostCreatorParams {
 	if b.Transport != "" {
 		params.Transport = &b.Transport
 	}
-	return &params
+	return &params, nil
+}
+
+// rateValue is a flag.Value for a float number of requests per second
+type rateValue struct {
+	rate *float64
+}
+
+func (r *rateValue) String() string {
+	if r.rate == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(*r.rate, 'f', -1, 64)
+}
+
+func (r *rateValue) Set(value string) error {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if rate < 0 {
+		return fmt.Errorf("rate must not be negative: %s", value)
+	}
+	*r.rate = rate
+	return nil
+}
+
+// sizeUnits are the suffixes accepted by sizeValue
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// sizeValue is a flag.Value for a number of bytes with an optional K, M or G suffix, e.g. 512M, 2GB or 1GiB
+type sizeValue struct {
+	size *int64
+}
+
+func (s *sizeValue) String() string {
+	if s.size == nil {
+		return "0"
+	}
+	return strconv.FormatInt(*s.size, 10)
+}
+
+func (s *sizeValue) Set(value string) error {
+	number, unit := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "I")
+	for _, candidate := range sizeUnits {
+		if trimmed := strings.TrimSuffix(number, candidate.suffix); trimmed != number {
+			number, unit = trimmed, candidate.size
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %w", value, err)
+	}
+	if size < 0 {
+		return fmt.Errorf("size must not be negative: %s", value)
+	}
+	*s.size = size * unit
+	return nil
 }
@@ -0,0 +1,94 @@
+package forge
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// withArgs runs fn with os.Args set to the forge command followed by args
+func withArgs(t *testing.T, args []string, fn func()) {
+	t.Helper()
+	original := os.Args
+	defer func() { os.Args = original }()
+	os.Args = append([]string{"forge"}, args...)
+	fn()
+}
+
+func TestParseCLIArguments(t *testing.T) {
+	t.Run("the output folder defaults to out", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, DefaultOutputFolder, args.OutputFolder, "output folder should be the default")
+			assert.Equal(t, ModeOutputFolder, args.Mode(), "mode should be output folder")
+		})
+	})
+
+	t.Run("--in-place clears the output folder", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id", "--in-place"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Empty(t, args.OutputFolder, "output folder should be empty")
+			assert.Equal(t, ModeInPlace, args.Mode(), "mode should be in-place")
+		})
+	})
+
+	t.Run("--in-place and --output are mutually exclusive", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id", "--in-place", "-o", "out"}, func() {
+			_, err := ParseCLIArguments()
+			assert.ErrorIs(t, err, ErrInPlaceOutput, "error should be ErrInPlaceOutput")
+		})
+	})
+
+	t.Run("a negative concurrency is rejected", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id", "--concurrency", "-1"}, func() {
+			_, err := ParseCLIArguments()
+			assert.ErrorIs(t, err, ErrInvalidConcurrency, "error should be ErrInvalidConcurrency")
+		})
+	})
+
+	t.Run("-f, --include and --exclude split comma separated values", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin,/bin/ls", "--include", "*sum,nc", "--exclude", "md5*,sha1*"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, []string{"/usr/bin", "/bin/ls"}, args.FilePaths, "every path should be an input")
+			assert.Equal(t, []string{"*sum", "nc"}, args.Inputs.Include, "every pattern should be included")
+			assert.Equal(t, []string{"md5*", "sha1*"}, args.Inputs.Exclude, "every pattern should be excluded")
+		})
+	})
+
+	t.Run("--commands splits comma separated names", func(t *testing.T) {
+		withArgs(t, []string{"--commands", "whoami,id,nc"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, []string{"whoami", "id", "nc"}, args.Commands, "every name should be a command")
+		})
+	})
+
+	t.Run("--preserve splits comma separated attributes", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id", "--preserve", "owner,timestamps"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, []string{"owner", "timestamps"}, args.Preserve, "every attribute should be preserved")
+		})
+	})
+
+	t.Run("--smoke-args keeps commas and is repeated for several arguments", func(t *testing.T) {
+		withArgs(t, []string{"-f", "/usr/bin/id", "--smoke-args", "-o", "--smoke-args", "pid,comm"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, []string{"-o", "pid,comm"}, args.SmokeArgs, "every flag should be one argument")
+		})
+	})
+
+	t.Run("the alpine config converts in place", func(t *testing.T) {
+		withArgs(t, []string{"-C", "configs/alpine.yaml"}, func() {
+			args, err := ParseCLIArguments()
+			assert.NoError(t, err, "error should be nil")
+			assert.True(t, args.InPlace, "in-place should be set")
+			assert.True(t, args.Yes, "yes should be set")
+			assert.Equal(t, ModeInPlace, args.Mode(), "mode should be in-place")
+		})
+	})
+}
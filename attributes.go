@@ -0,0 +1,113 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Attributes of the original file that can be preserved on its beacon, the mode is always preserved
+const (
+	// PreserveOwner preserves the uid and gid
+	PreserveOwner = "owner"
+	// PreserveTimestamps preserves the access and modification times
+	PreserveTimestamps = "timestamps"
+	// PreserveXattrs preserves the extended attributes, including file capabilities (security.capability),
+	// SELinux labels (security.selinux) and POSIX ACLs (system.posix_acl_access)
+	PreserveXattrs = "xattrs"
+	// PreserveAll preserves every attribute above
+	PreserveAll = "all"
+)
+
+// ErrInvalidPreserve is returned for an attribute that cannot be preserved
+var ErrInvalidPreserve = errors.New("invalid attribute to preserve")
+
+// errXattrsUnsupported is reported when extended attributes are requested on a platform without them
+var errXattrsUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// validatePreserve returns ErrInvalidPreserve for an unknown attribute
+func validatePreserve(attributes []string) error {
+	for _, attribute := range attributes {
+		switch attribute {
+		case PreserveOwner, PreserveTimestamps, PreserveXattrs, PreserveAll:
+		default:
+			return fmt.Errorf("%w %q, must be one of %s", ErrInvalidPreserve, attribute,
+				strings.Join([]string{PreserveOwner, PreserveTimestamps, PreserveXattrs, PreserveAll}, ", "))
+		}
+	}
+	return nil
+}
+
+// preserves reports whether the attribute of the originals is preserved
+func (o *Options) preserves(attribute string) bool {
+	for _, preserved := range o.Preserve {
+		if preserved == attribute || preserved == PreserveAll {
+			return true
+		}
+	}
+	return false
+}
+
+// preserveAttributes copies the mode and the requested attributes of the original file to the file at path.
+// Failing to copy the mode is an error, the other attributes that could not be copied are returned.
+func (r *Runner) preserveAttributes(original string, path string) ([]string, error) {
+	info, err := os.Stat(original)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file info: %w", err)
+	}
+	var unpreserved []string
+	if r.opts.preserves(PreserveOwner) {
+		// changing the owner clears the setuid and setgid bits and the file capabilities,
+		// so it comes before the mode and the extended attributes
+		if uid, gid, ok := fileOwner(info); !ok {
+			unpreserved = append(unpreserved, PreserveOwner+": not supported on this platform")
+		} else if err := os.Chown(path, uid, gid); err != nil {
+			unpreserved = append(unpreserved, fmt.Sprintf("%s: %s", PreserveOwner, unwrapPathError(err)))
+		}
+	}
+	if err := os.Chmod(path, info.Mode()); err != nil {
+		return unpreserved, fmt.Errorf("error changing file permissions: %w", err)
+	}
+	if r.opts.preserves(PreserveXattrs) {
+		unpreserved = append(unpreserved, copyXattrs(original, path)...)
+	}
+	if r.opts.preserves(PreserveTimestamps) {
+		if err := os.Chtimes(path, fileAccessTime(info), info.ModTime()); err != nil {
+			unpreserved = append(unpreserved, fmt.Sprintf("%s: %s", PreserveTimestamps, unwrapPathError(err)))
+		}
+	}
+	return unpreserved, nil
+}
+
+// copyXattrs copies the extended attributes of original to path and returns the ones that could not be copied
+func copyXattrs(original string, path string) []string {
+	names, err := listXattrs(original)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %s", PreserveXattrs, err)}
+	}
+	var unpreserved []string
+	for _, name := range names {
+		value, err := getXattr(original, name)
+		if err == nil {
+			err = setXattr(path, name, value)
+		}
+		if err != nil {
+			unpreserved = append(unpreserved, fmt.Sprintf("xattr %s: %s", name, err))
+		}
+	}
+	return unpreserved
+}
+
+// unwrapPathError drops the operation and path of err, they are already known from the result
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Err
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package forge
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+func TestRunner_PreserveXattrs(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("\x7fELF beacon"))
+	defer testServer.Close()
+
+	original := createTestOriginal(t)
+	if err := setXattr(original, "user.forge", []byte("label")); errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("the filesystem does not support extended attributes")
+	} else {
+		assert.NoError(t, err, "error should be nil")
+	}
+	runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Preserve: []string{PreserveXattrs}})
+
+	results, err := runner.Run(context.Background(), []string{original})
+	assert.NoError(t, err, "error should be nil")
+	value, err := getXattr(results[0].Destination, "user.forge")
+	assert.NoError(t, err, "error should be nil")
+	assert.Equal(t, "label", string(value), "extended attribute should be preserved")
+	assert.Empty(t, results[0].Unpreserved, "every attribute should be preserved")
+}
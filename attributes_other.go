@@ -0,0 +1,28 @@
+//go:build !linux
+
+package forge
+
+import (
+	"os"
+	"time"
+)
+
+// listXattrs returns the names of the extended attributes of the file, they are only supported on linux
+func listXattrs(path string) ([]string, error) {
+	return nil, errXattrsUnsupported
+}
+
+// getXattr returns the value of the extended attribute of the file, they are only supported on linux
+func getXattr(path string, name string) ([]byte, error) {
+	return nil, errXattrsUnsupported
+}
+
+// setXattr sets the extended attribute of the file, they are only supported on linux
+func setXattr(path string, name string, value []byte) error {
+	return errXattrsUnsupported
+}
+
+// fileAccessTime returns the last access time of the file, the modification time is used where it is not available
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
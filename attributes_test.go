@@ -0,0 +1,68 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createTestOriginal creates an executable named id in a temporary folder
+func createTestOriginal(t *testing.T) string {
+	t.Helper()
+	original := filepath.Join(t.TempDir(), "id")
+	testFile := createTestExecutable(t, "id")
+	assert.NoError(t, os.Rename(testFile.Name(), original), "error should be nil")
+	return original
+}
+
+func TestRunner_Preserve(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("\x7fELF beacon"))
+	defer testServer.Close()
+
+	t.Run("NewRunner rejects an unknown attribute", func(t *testing.T) {
+		isolateEnv(t)
+		_, err := NewRunner(nil, &Options{CreatorUrl: testServer.URL, Preserve: []string{"acl"}})
+		assert.ErrorIs(t, err, ErrInvalidPreserve, "error should be ErrInvalidPreserve")
+	})
+
+	t.Run("timestamps are preserved", func(t *testing.T) {
+		original := createTestOriginal(t)
+		mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		assert.NoError(t, os.Chtimes(original, mtime, mtime), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Preserve: []string{PreserveTimestamps}})
+
+		results, err := runner.Run(context.Background(), []string{original})
+		assert.NoError(t, err, "error should be nil")
+		info, err := os.Stat(results[0].Destination)
+		assert.NoError(t, err, "error should be nil")
+		assert.True(t, mtime.Equal(info.ModTime()), "modification time should be preserved")
+		assert.Empty(t, results[0].Unpreserved, "every attribute should be preserved")
+	})
+
+	t.Run("the owner and setuid bit are preserved or reported", func(t *testing.T) {
+		original := createTestOriginal(t)
+		assert.NoError(t, os.Chmod(original, 0755|os.ModeSetuid), "error should be nil")
+		owner := os.Geteuid()
+		if owner == 0 {
+			owner = 1234
+			assert.NoError(t, os.Chown(original, owner, owner), "error should be nil")
+			assert.NoError(t, os.Chmod(original, 0755|os.ModeSetuid), "error should be nil")
+		}
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Preserve: []string{PreserveAll}})
+
+		results, err := runner.Run(context.Background(), []string{original})
+		assert.NoError(t, err, "error should be nil")
+		info, err := os.Stat(results[0].Destination)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, 0755|os.ModeSetuid, info.Mode()&(os.ModePerm|os.ModeSetuid), "mode should be preserved after the owner")
+		if uid, _, ok := fileOwner(info); ok {
+			assert.Equal(t, owner, uid, "owner should be preserved")
+		} else {
+			assert.Contains(t, results[0].Cause(), PreserveOwner, "owner should be reported")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Environment variables read when the credentials are not set in the options
+const (
+	TokenEnv  = "FORGE_TOKEN"
+	APIKeyEnv = "FORGE_API_KEY"
+)
+
+// Secret is a string that is never printed or encoded, used for credentials
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Credentials authenticate forge against the gateway, matching the security schemes of the creator API
+type Credentials struct {
+	// Token is sent as a bearer token in the Authorization header
+	Token Secret `json:"token"`
+	// APIKey is sent in the X-API-Key header
+	APIKey Secret `json:"api_key"`
+}
+
+// DefaultCredentialsPath returns the credentials file read when none is configured
+func DefaultCredentialsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".forge", "credentials.json")
+}
+
+// LoadCredentials reads a JSON credentials file with the token and api_key keys
+func LoadCredentials(path string) (Credentials, error) {
+	var credentials Credentials
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return credentials, fmt.Errorf("error reading credentials file: %w", err)
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return credentials, fmt.Errorf("error decoding credentials file: %w", err)
+	}
+	return credentials, nil
+}
+
+// resolveCredentials returns the credentials of the options, completed from the environment
+// and then from the credentials file. The default credentials file is optional.
+func resolveCredentials(opts *Options) (Credentials, error) {
+	credentials := opts.Credentials
+	if credentials.Token == "" {
+		credentials.Token = Secret(os.Getenv(TokenEnv))
+	}
+	if credentials.APIKey == "" {
+		credentials.APIKey = Secret(os.Getenv(APIKeyEnv))
+	}
+	if credentials.Token != "" && credentials.APIKey != "" {
+		return credentials, nil
+	}
+	path := opts.CredentialsPath
+	if path == "" {
+		path = DefaultCredentialsPath()
+	}
+	if path == "" {
+		return credentials, nil
+	}
+	fromFile, err := LoadCredentials(path)
+	if errors.Is(err, os.ErrNotExist) && opts.CredentialsPath == "" {
+		return credentials, nil
+	}
+	if err != nil {
+		return credentials, err
+	}
+	if credentials.Token == "" {
+		credentials.Token = fromFile.Token
+	}
+	if credentials.APIKey == "" {
+		credentials.APIKey = fromFile.APIKey
+	}
+	return credentials, nil
+}
+
+// requestEditor returns a request editor adding the credentials to every request
+func (c Credentials) requestEditor() openapi.RequestEditorFn {
+	return func(ctx context.Context, req *http.Request) error {
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+string(c.Token))
+		}
+		if c.APIKey != "" {
+			req.Header.Set("X-API-Key", string(c.APIKey))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentials(t *testing.T) {
+	t.Run("Credentials are sent with every request", func(t *testing.T) {
+		var authorization, apiKey string
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization, apiKey = r.Header.Get("Authorization"), r.Header.Get("X-API-Key")
+			testCreatorHandler("test")(w, r)
+		}))
+		defer testServer.Close()
+		isolateEnv(t)
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, BackupDir: t.TempDir(), Credentials: Credentials{Token: "token", APIKey: "key"}})
+		assert.NoError(t, err, "error should be nil")
+
+		_, err = f.Distlist(context.Background())
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "Bearer token", authorization, "token should be sent as bearer token")
+		assert.Equal(t, "key", apiKey, "api key should be sent in X-API-Key")
+	})
+
+	t.Run("Credentials are completed from the credentials file", func(t *testing.T) {
+		isolateEnv(t)
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"token":"file-token","api_key":"file-key"}`), 0600), "error should be nil")
+		credentials, err := resolveCredentials(&Options{Credentials: Credentials{Token: "flag-token"}, CredentialsPath: path})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, Secret("flag-token"), credentials.Token, "token from the options should take precedence")
+		assert.Equal(t, Secret("file-key"), credentials.APIKey, "api key should be read from the file")
+	})
+
+	t.Run("Credentials are read from the environment before the default credentials file", func(t *testing.T) {
+		isolateEnv(t)
+		home, err := os.UserHomeDir()
+		assert.NoError(t, err, "error should be nil")
+		assert.NoError(t, os.MkdirAll(filepath.Join(home, ".forge"), 0700), "error should be nil")
+		assert.NoError(t, os.WriteFile(DefaultCredentialsPath(), []byte(`{"token":"file-token","api_key":"file-key"}`), 0600), "error should be nil")
+		t.Setenv(TokenEnv, "env-token")
+		credentials, err := resolveCredentials(&Options{})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, Secret("env-token"), credentials.Token, "token from the environment should take precedence")
+		assert.Equal(t, Secret("file-key"), credentials.APIKey, "api key should be read from the default file")
+	})
+
+	t.Run("Credentials are never encoded", func(t *testing.T) {
+		data, err := json.Marshal(Options{Credentials: Credentials{Token: "token", APIKey: "key"}})
+		assert.NoError(t, err, "error should be nil")
+		assert.Contains(t, string(data), `"token":"[REDACTED]"`, "token should be redacted")
+		assert.Contains(t, string(data), `"api_key":"[REDACTED]"`, "api key should be redacted")
+	})
+}
@@ -0,0 +1,313 @@
+package forge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoBackup is returned when restoring a file that has no backup
+var ErrNoBackup = errors.New("no backup found")
+
+// backupIndexFile is the name of the manifest of the backup store
+const backupIndexFile = "index.json"
+
+// BackupEntry records the original of a file that was overwritten by a beacon
+type BackupEntry struct {
+	// Path is the absolute path of the original file
+	Path string `json:"path"`
+	// SHA256 is the hash of the original file, the backup is stored under this name
+	SHA256 string `json:"sha256,omitempty"`
+	// Beacon is the hash of the last beacon installed over the original, a file at Path with another
+	// hash was replaced since, e.g. by a package upgrade, and is backed up again
+	Beacon string `json:"beacon,omitempty"`
+	// LinkTarget is the target of the original when it was a symbolic link, nothing is stored in that case
+	LinkTarget string      `json:"link_target,omitempty"`
+	Mode       os.FileMode `json:"mode"`
+	Uid        int         `json:"uid"`
+	Gid        int         `json:"gid"`
+	// CreatedAt is when the backup was taken
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupStore keeps copies of the original files, named by their SHA-256, together with an index
+// mapping the original paths to their copies. Symbolic links are recorded with their target instead.
+// A backup is kept as long as the file is the beacon installed over it, so that converting a file twice
+// does not replace the original with a beacon, and refreshed once the file was replaced by something else.
+type BackupStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// DefaultBackupDir returns the backup store used when none is configured
+func DefaultBackupDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "forge", "backups")
+	}
+	return filepath.Join(home, ".forge", "backups")
+}
+
+// OpenBackupStore opens the backup store in dir, creating it if needed
+func OpenBackupStore(dir string) (*BackupStore, error) {
+	if dir == "" {
+		dir = DefaultBackupDir()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating backup store: %w", err)
+	}
+	return &BackupStore{dir: dir}, nil
+}
+
+// Dir returns the folder of the backup store
+func (s *BackupStore) Dir() string {
+	return s.dir
+}
+
+// Backup copies the file at path into the store and records it in the index together with the hash of
+// the beacon about to replace it. If the path already has a backup and the file is still the beacon recorded
+// with it, the existing entry is returned and nothing is copied. A file that was replaced since the
+// last beacon was installed, e.g. by a package upgrade, is backed up again.
+func (s *BackupStore) Backup(path string, beacon string) (*BackupEntry, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	previous, ok := index[path]
+	if ok && !s.outdated(&previous) {
+		previous.Beacon = beacon
+		index[path] = previous
+		if err := s.writeIndex(index); err != nil {
+			return nil, err
+		}
+		return &previous, nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file info: %w", err)
+	}
+	entry := BackupEntry{Path: path, Mode: info.Mode(), Beacon: beacon, CreatedAt: time.Now().UTC()}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if entry.LinkTarget, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("error reading link: %w", err)
+		}
+	} else if entry.SHA256, err = s.copyIn(path); err != nil {
+		return nil, err
+	}
+	entry.Uid, entry.Gid, _ = fileOwner(info)
+	index[path] = entry
+	if err := s.writeIndex(index); err != nil {
+		return nil, err
+	}
+	if ok {
+		// the outdated original is not restored anymore
+		if err := s.removeUnused(&previous, index); err != nil {
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+// outdated reports whether the file at the path of entry was replaced since its last beacon was installed.
+// Entries without a recorded beacon and link entries are never outdated.
+func (s *BackupStore) outdated(entry *BackupEntry) bool {
+	if entry.Beacon == "" || entry.LinkTarget != "" {
+		return false
+	}
+	current, err := digestFile(entry.Path)
+	return err == nil && current.SHA256 != entry.Beacon && current.SHA256 != entry.SHA256
+}
+
+// removeUnused removes the copy of entry unless another entry of index uses it
+func (s *BackupStore) removeUnused(entry *BackupEntry, index map[string]BackupEntry) error {
+	if entry.LinkTarget != "" {
+		return nil
+	}
+	for _, other := range index {
+		if other.SHA256 == entry.SHA256 {
+			return nil
+		}
+	}
+	return os.Remove(s.BlobPath(entry))
+}
+
+// BlobPath returns the path of the copy stored for entry
+func (s *BackupStore) BlobPath(entry *BackupEntry) string {
+	return filepath.Join(s.dir, entry.SHA256)
+}
+
+// copyIn copies the file into the store and returns its hash
+func (s *BackupStore) copyIn(path string) (string, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %w", err)
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp(s.dir, ".backup-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating backup file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, hasher), source); err != nil {
+		return "", fmt.Errorf("error copying file to backup: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		return "", fmt.Errorf("error syncing backup file: %w", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if err := os.Rename(temp.Name(), filepath.Join(s.dir, hash)); err != nil {
+		return "", fmt.Errorf("error renaming backup file: %w", err)
+	}
+	return hash, nil
+}
+
+// Entries returns all backups in the store sorted by path
+func (s *BackupStore) Entries() ([]BackupEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]BackupEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// Restore puts the original file back at path with its permissions and owner,
+// and removes it from the store
+func (s *BackupStore) Restore(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	entry, ok := index[path]
+	if !ok {
+		return fmt.Errorf("%w for %s", ErrNoBackup, path)
+	}
+	if err := s.copyOut(&entry); err != nil {
+		return err
+	}
+	delete(index, path)
+	if err := s.writeIndex(index); err != nil {
+		return err
+	}
+	return s.removeUnused(&entry, index)
+}
+
+// copyOut writes the backup of entry next to its original path and renames it in place
+func (s *BackupStore) copyOut(entry *BackupEntry) error {
+	if entry.LinkTarget != "" {
+		return restoreLink(entry)
+	}
+	blob, err := os.Open(s.BlobPath(entry))
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer blob.Close()
+	temp, err := os.CreateTemp(filepath.Dir(entry.Path), "."+filepath.Base(entry.Path)+"-restore-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, blob); err != nil {
+		return fmt.Errorf("error copying backup: %w", err)
+	}
+	// chown clears the setuid and setgid bits, so the owner is set before the mode
+	if entry.Uid >= 0 {
+		if err := temp.Chown(entry.Uid, entry.Gid); err != nil && !errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("error changing file owner: %w", err)
+		}
+	}
+	if err := temp.Chmod(entry.Mode); err != nil {
+		return fmt.Errorf("error changing file permissions: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		return fmt.Errorf("error syncing restored file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), entry.Path); err != nil {
+		return fmt.Errorf("error renaming restored file: %w", err)
+	}
+	return nil
+}
+
+// restoreLink recreates the symbolic link of entry next to its original path and renames it in place
+func restoreLink(entry *BackupEntry) error {
+	temp := filepath.Join(filepath.Dir(entry.Path), "."+filepath.Base(entry.Path)+"-restore-link")
+	os.Remove(temp)
+	if err := os.Symlink(entry.LinkTarget, temp); err != nil {
+		return fmt.Errorf("error creating link: %w", err)
+	}
+	if entry.Uid >= 0 {
+		if err := os.Lchown(temp, entry.Uid, entry.Gid); err != nil && !errors.Is(err, os.ErrPermission) {
+			os.Remove(temp)
+			return fmt.Errorf("error changing link owner: %w", err)
+		}
+	}
+	if err := os.Rename(temp, entry.Path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error renaming restored link: %w", err)
+	}
+	return nil
+}
+
+func (s *BackupStore) readIndex() (map[string]BackupEntry, error) {
+	index := map[string]BackupEntry{}
+	data, err := os.ReadFile(filepath.Join(s.dir, backupIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("error decoding backup index: %w", err)
+	}
+	return index, nil
+}
+
+func (s *BackupStore) writeIndex(index map[string]BackupEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding backup index: %w", err)
+	}
+	temp := filepath.Join(s.dir, backupIndexFile+".tmp")
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("error writing backup index: %w", err)
+	}
+	if err := os.Rename(temp, filepath.Join(s.dir, backupIndexFile)); err != nil {
+		return fmt.Errorf("error writing backup index: %w", err)
+	}
+	return nil
+}
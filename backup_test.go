@@ -0,0 +1,82 @@
+package forge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupStore(t *testing.T) {
+	t.Run("BackupStore restores the original with its permissions", func(t *testing.T) {
+		store, err := OpenBackupStore(t.TempDir())
+		assert.NoError(t, err, "error should be nil")
+		original := filepath.Join(t.TempDir(), "original")
+		assert.NoError(t, os.WriteFile(original, []byte("original"), 0750), "error should be nil")
+
+		entry, err := store.Backup(original, "")
+		assert.NoError(t, err, "error should be nil")
+		assert.FileExists(t, store.BlobPath(entry), "backup should be stored by hash")
+		assert.NoError(t, os.WriteFile(original, []byte("beacon"), 0600), "error should be nil")
+
+		assert.NoError(t, store.Restore(original), "error should be nil")
+		content, err := os.ReadFile(original)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, []byte("original"), content, "original content should be restored")
+		info, err := os.Stat(original)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), "original permissions should be restored")
+		assert.NoFileExists(t, store.BlobPath(entry), "backup should be removed once restored")
+	})
+
+	t.Run("BackupStore keeps the first backup of a path", func(t *testing.T) {
+		store, err := OpenBackupStore(t.TempDir())
+		assert.NoError(t, err, "error should be nil")
+		original := filepath.Join(t.TempDir(), "original")
+		assert.NoError(t, os.WriteFile(original, []byte("original"), 0755), "error should be nil")
+
+		beacon := sha256Hex("beacon")
+		first, err := store.Backup(original, beacon)
+		assert.NoError(t, err, "error should be nil")
+		assert.NoError(t, os.WriteFile(original, []byte("beacon"), 0755), "error should be nil")
+		second, err := store.Backup(original, beacon)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, first.SHA256, second.SHA256, "the original should not be replaced by the beacon")
+	})
+
+	t.Run("BackupStore backs up a file again once it replaced the beacon", func(t *testing.T) {
+		store, err := OpenBackupStore(t.TempDir())
+		assert.NoError(t, err, "error should be nil")
+		original := filepath.Join(t.TempDir(), "original")
+		assert.NoError(t, os.WriteFile(original, []byte("original"), 0755), "error should be nil")
+
+		first, err := store.Backup(original, sha256Hex("beacon"))
+		assert.NoError(t, err, "error should be nil")
+		// a package upgrade replaces the beacon
+		assert.NoError(t, os.WriteFile(original, []byte("upgraded"), 0755), "error should be nil")
+		second, err := store.Backup(original, sha256Hex("beacon"))
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, sha256Hex("upgraded"), second.SHA256, "the upgraded file should be backed up")
+		assert.NoFileExists(t, store.BlobPath(first), "the outdated backup should be removed")
+
+		assert.NoError(t, os.WriteFile(original, []byte("beacon"), 0755), "error should be nil")
+		assert.NoError(t, store.Restore(original), "error should be nil")
+		content, err := os.ReadFile(original)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "upgraded", string(content), "the upgraded file should be restored")
+	})
+
+	t.Run("BackupStore returns ErrNoBackup for unknown files", func(t *testing.T) {
+		store, err := OpenBackupStore(t.TempDir())
+		assert.NoError(t, err, "error should be nil")
+		assert.ErrorIs(t, store.Restore(filepath.Join(t.TempDir(), "unknown")), ErrNoBackup, "error should be ErrNoBackup")
+	})
+}
+
+// sha256Hex returns the hex encoded SHA-256 of content
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,368 @@
+package forge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// cacheIndexFile is the name of the index of the cache
+const cacheIndexFile = "index.json"
+
+const (
+	// DefaultCacheTTL is how long cached beacons are used when no TTL is configured
+	DefaultCacheTTL = 7 * 24 * time.Hour
+	// DefaultCacheMaxSize is the size the cache is pruned to when no size is configured
+	DefaultCacheMaxSize int64 = 1 << 30
+)
+
+// CacheEntry records a beacon kept in the cache
+type CacheEntry struct {
+	// Key is the SHA-256 of the input hash and the canonical params, the beacon is stored under this name
+	Key string `json:"key"`
+	// Path is the file the beacon was first created for
+	Path   string                     `json:"path"`
+	Source Digest                     `json:"source"`
+	Beacon Digest                     `json:"beacon"`
+	Params *openapi.PostCreatorParams `json:"params"`
+	// CreatedAt is when the beacon was cached, it expires after the TTL of the cache
+	CreatedAt time.Time `json:"created_at"`
+	// UsedAt is when the beacon was last used, the least recently used beacons are pruned first
+	UsedAt time.Time `json:"used_at"`
+}
+
+// Cache keeps the beacons returned by the creator, keyed by the content of the input and the params
+// sent with it, so converting the same binary again does not need the creator.
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// DefaultCacheDir returns the cache used when none is configured
+func DefaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "forge", "cache")
+	}
+	return filepath.Join(home, ".forge", "cache")
+}
+
+// OpenCache opens the cache in dir, creating it if needed.
+// Entries older than ttl are not used and the cache is pruned to maxSize bytes, 0 disables either limit.
+func OpenCache(dir string, ttl time.Duration, maxSize int64) (*Cache, error) {
+	if dir == "" {
+		dir = DefaultCacheDir()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache: %w", err)
+	}
+	return &Cache{dir: dir, ttl: ttl, maxSize: maxSize}, nil
+}
+
+// Dir returns the folder of the cache
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// cacheKey returns the key of the beacon created for the input with the source digest and params
+func cacheKey(source Digest, params *openapi.PostCreatorParams) string {
+	key := sha256.Sum256([]byte(source.SHA256 + "\n" + paramsKey(params)))
+	return hex.EncodeToString(key[:])
+}
+
+// BlobPath returns the path of the beacon stored for entry
+func (c *Cache) BlobPath(entry *CacheEntry) string {
+	return filepath.Join(c.dir, entry.Key)
+}
+
+// Get returns the entry for key, nil if there is none or it expired
+func (c *Cache) Get(key string) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := index[key]
+	if !ok || c.expired(&entry, time.Now()) {
+		return nil, nil
+	}
+	if _, err := os.Stat(c.BlobPath(&entry)); err != nil {
+		return nil, nil
+	}
+	entry.UsedAt = time.Now().UTC()
+	index[key] = entry
+	if err := c.writeIndex(index); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Put copies the beacon at beaconPath into the cache under entry.Key and prunes the cache
+func (c *Cache) Put(entry CacheEntry, beaconPath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.copyIn(beaconPath, entry.Key); err != nil {
+		return err
+	}
+	index, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+	entry.CreatedAt = time.Now().UTC()
+	entry.UsedAt = entry.CreatedAt
+	index[entry.Key] = entry
+	if _, err := c.prune(index, false); err != nil {
+		return err
+	}
+	return c.writeIndex(index)
+}
+
+// copyIn copies the file at path into the cache under key
+func (c *Cache) copyIn(path string, key string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening beacon: %w", err)
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp(c.dir, ".cache-*")
+	if err != nil {
+		return fmt.Errorf("error creating cache file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	if _, err := io.Copy(temp, source); err != nil {
+		return fmt.Errorf("error copying beacon to cache: %w", err)
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(c.dir, key)); err != nil {
+		return fmt.Errorf("error renaming cache file: %w", err)
+	}
+	return nil
+}
+
+// Entries returns all beacons in the cache, most recently used first
+func (c *Cache) Entries() ([]CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	return sortedCacheEntries(index), nil
+}
+
+// Remove removes the beacon stored under key, if any
+func (c *Cache) Remove(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := index[key]; !ok {
+		return nil
+	}
+	delete(index, key)
+	if err := os.Remove(filepath.Join(c.dir, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing cached beacon: %w", err)
+	}
+	return c.writeIndex(index)
+}
+
+// Prune removes the expired beacons and the least recently used ones until the cache fits its size.
+// If all is set every beacon is removed. It returns the removed entries.
+func (c *Cache) Prune(all bool) ([]CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	removed, err := c.prune(index, all)
+	if err != nil {
+		return removed, err
+	}
+	return removed, c.writeIndex(index)
+}
+
+func (c *Cache) prune(index map[string]CacheEntry, all bool) ([]CacheEntry, error) {
+	var removed []CacheEntry
+	remove := func(entry CacheEntry) error {
+		if err := os.Remove(c.BlobPath(&entry)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing cached beacon: %w", err)
+		}
+		delete(index, entry.Key)
+		removed = append(removed, entry)
+		return nil
+	}
+	var size int64
+	now := time.Now()
+	entries := sortedCacheEntries(index)
+	kept := entries[:0]
+	for _, entry := range entries {
+		if all || c.expired(&entry, now) {
+			if err := remove(entry); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		size += entry.Beacon.Size
+		kept = append(kept, entry)
+	}
+	for i := len(kept) - 1; i >= 0 && c.maxSize > 0 && size > c.maxSize; i-- {
+		if err := remove(kept[i]); err != nil {
+			return removed, err
+		}
+		size -= kept[i].Beacon.Size
+	}
+	return removed, nil
+}
+
+func (c *Cache) expired(entry *CacheEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(entry.CreatedAt) > c.ttl
+}
+
+func sortedCacheEntries(index map[string]CacheEntry) []CacheEntry {
+	entries := make([]CacheEntry, 0, len(index))
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UsedAt.After(entries[j].UsedAt)
+	})
+	return entries
+}
+
+func (c *Cache) readIndex() (map[string]CacheEntry, error) {
+	index := map[string]CacheEntry{}
+	data, err := os.ReadFile(filepath.Join(c.dir, cacheIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("error decoding cache index: %w", err)
+	}
+	return index, nil
+}
+
+func (c *Cache) writeIndex(index map[string]CacheEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cache index: %w", err)
+	}
+	temp := filepath.Join(c.dir, cacheIndexFile+".tmp")
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("error writing cache index: %w", err)
+	}
+	if err := os.Rename(temp, filepath.Join(c.dir, cacheIndexFile)); err != nil {
+		return fmt.Errorf("error writing cache index: %w", err)
+	}
+	return nil
+}
+
+// WriteCacheEntries writes the entries as a table, or as JSON if asJSON is set
+func WriteCacheEntries(w io.Writer, entries []CacheEntry, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KEY\tPATH\tTARGET\tSIZE\tCREATED\tUSED")
+	for _, entry := range entries {
+		target := ""
+		if entry.Params != nil {
+			target = Target{Os: entry.Params.Os, Arch: entry.Params.Arch}.String()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\n", entry.Key[:12], entry.Path, target, entry.Beacon.Size,
+			entry.CreatedAt.Local().Format(time.DateTime), entry.UsedAt.Local().Format(time.DateTime))
+	}
+	return table.Flush()
+}
+
+// cachedUpload returns the cached beacon for the file, uploading it on a miss.
+// Uploaded beacons are cached by cacheBeacon once they passed the smoke test.
+func (r *Runner) cachedUpload(ctx context.Context, filePath string, source Digest, target Target, params *openapi.PostCreatorParams) (*TempBinary, error) {
+	if r.cache == nil {
+		return r.uploadBinary(ctx, filePath, target, params)
+	}
+	key := cacheKey(source, params)
+	entry, err := r.cache.Get(key)
+	if err != nil {
+		r.logger.Warn("error reading cache", zap.Error(err))
+	}
+	if entry != nil {
+		binary, err := r.cachedBeacon(filePath, entry, target)
+		if err == nil {
+			r.logger.With(zap.String("file", filePath), zap.String("key", key)).Info("Using cached beacon")
+			return binary, nil
+		}
+		r.logger.With(zap.String("file", filePath), zap.String("key", key)).Warn("Not using cached beacon", zap.Error(err))
+		if errors.Is(err, ErrInvalidBeacon) {
+			if err := r.cache.Remove(key); err != nil {
+				r.logger.Warn("error evicting cached beacon", zap.String("file", filePath), zap.Error(err))
+			}
+		}
+	}
+	return r.uploadBinary(ctx, filePath, target, params)
+}
+
+// cachedBeacon copies the cached beacon of entry to a temp file and validates it like an uploaded beacon,
+// against the size and hash recorded in the cache
+func (r *Runner) cachedBeacon(filePath string, entry *CacheEntry, target Target) (*TempBinary, error) {
+	blob, err := os.Open(r.cache.BlobPath(entry))
+	if err != nil {
+		return nil, fmt.Errorf("error opening cached beacon: %w", err)
+	}
+	raw := &countingReader{ReadCloser: blob}
+	body := &beaconBody{ReadCloser: raw, raw: raw, contentLength: entry.Beacon.Size, checksum: entry.Beacon.SHA256}
+	defer body.Close()
+	binary, err := r.createTempBinaryFile(filePath, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBeacon(body, binary, target); err != nil {
+		removeTempBinary(binary)
+		return nil, err
+	}
+	binary.cached = true
+	return binary, nil
+}
+
+// cacheBeacon caches an uploaded beacon that passed validation and the smoke test
+func (r *Runner) cacheBeacon(filePath string, binary *TempBinary) {
+	if r.cache == nil || binary.cached {
+		return
+	}
+	entry := CacheEntry{Key: cacheKey(binary.source, binary.params), Path: filePath, Source: binary.source, Beacon: binary.beacon, Params: binary.params}
+	if err := r.cache.Put(entry, binary.tempFilePath.Name()); err != nil {
+		r.logger.Warn("error caching beacon", zap.String("file", filePath), zap.Error(err))
+	}
+}
+
+// evictBeacon removes a beacon that failed the smoke test from the cache so it is not reused
+func (r *Runner) evictBeacon(filePath string, binary *TempBinary) {
+	if r.cache == nil {
+		return
+	}
+	if err := r.cache.Remove(cacheKey(binary.source, binary.params)); err != nil {
+		r.logger.Warn("error evicting cached beacon", zap.String("file", filePath), zap.Error(err))
+	}
+}
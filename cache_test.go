@@ -0,0 +1,171 @@
+package forge
+
+import (
+	"context"
+	"github.com/SekyrOrg/forge/openapi"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// putTestBeacon caches a beacon with content under key
+func putTestBeacon(t *testing.T, cache *Cache, key string, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "beacon")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644), "error should be nil")
+	entry := CacheEntry{Key: key, Path: path, Beacon: Digest{Size: int64(len(content))}, Params: &openapi.PostCreatorParams{Os: "linux", Arch: "amd64"}}
+	assert.NoError(t, cache.Put(entry, path), "error should be nil")
+}
+
+func TestCache(t *testing.T) {
+	t.Run("Get returns the beacon stored by Put", func(t *testing.T) {
+		cache, err := OpenCache(t.TempDir(), time.Hour, 0)
+		assert.NoError(t, err, "error should be nil")
+		putTestBeacon(t, cache, "key", "beacon")
+
+		entry, err := cache.Get("key")
+		assert.NoError(t, err, "error should be nil")
+		assert.NotNil(t, entry, "entry should be found")
+		content, err := os.ReadFile(cache.BlobPath(entry))
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "beacon", string(content), "content should be the beacon")
+		entry, err = cache.Get("other")
+		assert.NoError(t, err, "error should be nil")
+		assert.Nil(t, entry, "unknown key should be a miss")
+	})
+
+	t.Run("Get ignores expired beacons and Prune removes them", func(t *testing.T) {
+		cache, err := OpenCache(t.TempDir(), 20*time.Millisecond, 0)
+		assert.NoError(t, err, "error should be nil")
+		putTestBeacon(t, cache, "key", "beacon")
+		time.Sleep(50 * time.Millisecond)
+
+		entry, err := cache.Get("key")
+		assert.NoError(t, err, "error should be nil")
+		assert.Nil(t, entry, "expired beacon should be a miss")
+		removed, err := cache.Prune(false)
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, removed, 1, "expired beacon should be pruned")
+		assert.NoFileExists(t, filepath.Join(cache.Dir(), "key"), "blob should be removed")
+	})
+
+	t.Run("Put evicts the least recently used beacons beyond the size cap", func(t *testing.T) {
+		cache, err := OpenCache(t.TempDir(), 0, 10)
+		assert.NoError(t, err, "error should be nil")
+		putTestBeacon(t, cache, "first", "12345")
+		putTestBeacon(t, cache, "second", "12345")
+		_, err = cache.Get("first")
+		assert.NoError(t, err, "error should be nil")
+		putTestBeacon(t, cache, "third", "12345")
+
+		entries, err := cache.Entries()
+		assert.NoError(t, err, "error should be nil")
+		var keys []string
+		for _, entry := range entries {
+			keys = append(keys, entry.Key)
+		}
+		assert.ElementsMatch(t, []string{"first", "third"}, keys, "second should be evicted")
+	})
+
+	t.Run("Prune with all empties the cache", func(t *testing.T) {
+		cache, err := OpenCache(t.TempDir(), 0, 0)
+		assert.NoError(t, err, "error should be nil")
+		putTestBeacon(t, cache, "key", "beacon")
+		_, err = cache.Prune(true)
+		assert.NoError(t, err, "error should be nil")
+		entries, err := cache.Entries()
+		assert.NoError(t, err, "error should be nil")
+		assert.Empty(t, entries, "cache should be empty")
+	})
+}
+
+func TestRunner_Cache(t *testing.T) {
+	const beacon = "\x7fELF beacon"
+	testServer := httptest.NewServer(testCreatorHandler(beacon))
+	cacheDir := t.TempDir()
+	testFile := createTestExecutable(t, "test")
+	defer os.Remove(testFile.Name())
+
+	runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), CacheDir: cacheDir})
+	_, err := runner.Run(context.Background(), []string{testFile.Name()})
+	assert.NoError(t, err, "error should be nil")
+	testServer.Close()
+
+	t.Run("cached beacons are used while the creator is down", func(t *testing.T) {
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), CacheDir: cacheDir})
+		results, err := runner.Run(context.Background(), []string{testFile.Name()})
+		assert.NoError(t, err, "error should be nil")
+		content, err := os.ReadFile(results[0].Destination)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, string(testELF(beacon)), string(content), "the cached beacon should be installed")
+	})
+
+	t.Run("NoCache ignores the cache", func(t *testing.T) {
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), CacheDir: cacheDir, NoCache: true, Retries: -1})
+		_, err := runner.Run(context.Background(), []string{testFile.Name()})
+		assert.Error(t, err, "the creator should be needed")
+	})
+}
+
+func TestRunner_CacheValidation(t *testing.T) {
+	const beacon = "\x7fELF beacon"
+	tests := []struct {
+		name   string
+		cached []byte
+	}{
+		{"truncated", testELF(beacon)[:32]},
+		{"tampered", testELF("\x7fELF other")},
+	}
+	for _, test := range tests {
+		t.Run(test.name+" cached beacons are evicted and uploaded again", func(t *testing.T) {
+			var uploads atomic.Int32
+			handler := testCreatorHandler(beacon)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/creator/distlist" {
+					uploads.Add(1)
+				}
+				handler(w, r)
+			}))
+			defer testServer.Close()
+			cacheDir := t.TempDir()
+			testFile := createTestExecutable(t, "test")
+			defer os.Remove(testFile.Name())
+			runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), CacheDir: cacheDir})
+			_, err := runner.Run(context.Background(), []string{testFile.Name()})
+			assert.NoError(t, err, "error should be nil")
+
+			cache, err := OpenCache(cacheDir, 0, 0)
+			assert.NoError(t, err, "error should be nil")
+			entries, err := cache.Entries()
+			assert.NoError(t, err, "error should be nil")
+			assert.NoError(t, os.WriteFile(cache.BlobPath(&entries[0]), test.cached, 0600), "error should be nil")
+
+			runner = newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), CacheDir: cacheDir})
+			results, err := runner.Run(context.Background(), []string{testFile.Name()})
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, int32(2), uploads.Load(), "the beacon should be uploaded again")
+			content, err := os.ReadFile(results[0].Destination)
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, string(testELF(beacon)), string(content), "the uploaded beacon should be installed")
+			content, err = os.ReadFile(cache.BlobPath(&entries[0]))
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, string(testELF(beacon)), string(content), "the cache should hold the uploaded beacon again")
+		})
+	}
+}
+
+func TestSizeValue(t *testing.T) {
+	for value, expected := range map[string]int64{"100": 100, "512M": 512 << 20, "2GB": 2 << 30, "1GiB": 1 << 30, "4k": 4 << 10} {
+		var size int64
+		assert.NoError(t, (&sizeValue{&size}).Set(value), "error should be nil")
+		assert.Equal(t, expected, size, "size of %s should match", value)
+	}
+	var size int64
+	assert.Error(t, (&sizeValue{&size}).Set("-1M"), "negative sizes should be rejected")
+	assert.Error(t, (&sizeValue{&size}).Set("big"), "invalid sizes should be rejected")
+}
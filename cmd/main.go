@@ -1,34 +1,201 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/SekyrOrg/forge"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/term"
+	"io"
 	"os"
-	"runtime"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
+// logLevel is raised to debug with the verbose flag
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// commands are the subcommands of forge, without a subcommand the files are converted into beacons
+var commands = map[string]func(logger *zap.Logger){
+	"distlist": runDistlist,
+	"restore":  runRestore,
+	"cache":    runCache,
+}
+
+var cacheCommands = map[string]func(logger *zap.Logger, cache *forge.Cache, arguments *forge.CacheArgs){
+	"ls":    runCacheList,
+	"prune": runCachePrune,
+}
+
 func main() {
-	numCPUs := runtime.NumCPU()
-	runtime.GOMAXPROCS(numCPUs * 2)
 	logger := CreateZapLogger()
 	defer logger.Sync()
 
-	arguments := forge.ParseCLIArguments()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			// remove the subcommand so the flags of the subcommand are parsed
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			command(logger)
+			return
+		}
+	}
+	runForge(logger)
+}
+
+func runForge(logger *zap.Logger) {
+	arguments, err := forge.ParseCLIArguments()
+	if err != nil {
+		logger.Fatal("error parsing arguments", zap.Error(err))
+	}
+	if arguments.Verbose {
+		logLevel.SetLevel(zap.DebugLevel)
+	}
+	// the PATH of the host does not apply to a root filesystem, DefaultPath is used for it
+	pathEnv := os.Getenv("PATH")
+	if arguments.Root != "" {
+		pathEnv = ""
+	}
+	commands, unresolved := forge.ResolveCommands(arguments.Commands, arguments.Root, pathEnv)
+	if len(unresolved) > 0 {
+		logger.Error("some commands could not be resolved", zap.Strings("commands", unresolved))
+	}
+	files, err := forge.ExpandInputs(append(arguments.FilePaths, commands...), arguments.Inputs)
+	if err != nil {
+		logger.Fatal("error resolving files", zap.Error(err))
+	}
+	if arguments.DryRun {
+		runPlan(logger, arguments, files, unresolved)
+		return
+	}
+	if arguments.InPlace && !arguments.Yes && !confirm(os.Stdin, os.Stderr, fmt.Sprintf("Overwrite %d files in place? [y/N] ", len(files))) {
+		logger.Fatal("in-place run not confirmed, use --yes to skip the confirmation")
+	}
 	logger.
-		With(zap.Strings("files", arguments.FilePaths)).
+		With(zap.Strings("files", files), zap.String("mode", arguments.Mode())).
 		Info("beaconForge Starting")
 
-	runner, err := forge.NewRunner(logger, arguments)
+	f, err := forge.New(logger, arguments.Options)
 	if err != nil {
-		logger.Fatal("error creating runner", zap.Error(err))
+		logger.Fatal("error creating forge", zap.Error(err))
 	}
 
-	if err := runner.Run(); err != nil {
+	// cancel the run on interrupt so the temp files are removed before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	results, err := f.Forge(ctx, files)
+	if results != nil {
+		if err := forge.WriteSummary(os.Stdout, results, &arguments.Options); err != nil {
+			logger.Error("error writing summary", zap.Error(err))
+		}
+	}
+	if err != nil {
 		logger.Fatal("beaconForge encountered an error", zap.Error(err))
 	}
+	if len(unresolved) > 0 {
+		logger.Fatal("beaconForge finished, but some commands could not be resolved", zap.Strings("commands", unresolved))
+	}
 	logger.Info("beaconForge finished successfully!")
+}
+
+// confirm asks the question on out and reports whether the answer read from in is yes,
+// it never confirms when in is not a terminal
+func confirm(in *os.File, out io.Writer, question string) bool {
+	if !term.IsTerminal(int(in.Fd())) {
+		return false
+	}
+	fmt.Fprint(out, question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func runPlan(logger *zap.Logger, arguments *forge.Args, files []string, unresolved []string) {
+	// the plan must not create the backup store or the cache
+	arguments.NoBackup, arguments.NoCache = true, true
+	f, err := forge.New(logger, arguments.Options)
+	if err != nil {
+		logger.Fatal("error creating forge", zap.Error(err))
+	}
+	logger.Info("Planning run", zap.String("mode", arguments.Mode()), zap.String("output", arguments.OutputFolder))
+	plan, err := f.Plan(context.Background(), files)
+	if err != nil && !errors.Is(err, forge.ErrNoFiles) {
+		logger.Fatal("error planning run", zap.Error(err))
+	}
+	for _, command := range unresolved {
+		plan = append(plan, &forge.PlanEntry{Path: command, Action: forge.ActionSkip, Problems: []string{"unresolved command"}})
+	}
+	if err := forge.WritePlan(os.Stdout, plan, arguments.JSON); err != nil {
+		logger.Fatal("error writing plan", zap.Error(err))
+	}
+	if forge.HasProblems(plan) {
+		logger.Fatal("some files would not be converted")
+	}
+}
+
+func runDistlist(logger *zap.Logger) {
+	arguments, err := forge.ParseDistlistArguments()
+	if err != nil {
+		logger.Fatal("error parsing arguments", zap.Error(err))
+	}
+	f, err := forge.New(logger, arguments.Options)
+	if err != nil {
+		logger.Fatal("error creating forge", zap.Error(err))
+	}
+	targets, err := f.Distlist(context.Background())
+	if err != nil {
+		logger.Fatal("error fetching distlist", zap.Error(err))
+	}
+	if err := forge.WriteDistlist(os.Stdout, targets, arguments.JSON); err != nil {
+		logger.Fatal("error writing distlist", zap.Error(err))
+	}
+}
+
+func runRestore(logger *zap.Logger) {
+	arguments, err := forge.ParseRestoreArguments()
+	if err != nil {
+		logger.Fatal("error parsing arguments", zap.Error(err))
+	}
+	store, err := forge.OpenBackupStore(arguments.BackupDir)
+	if err != nil {
+		logger.Fatal("error opening backup store", zap.Error(err))
+	}
+	entries, err := store.Entries()
+	if err != nil {
+		logger.Fatal("error reading backup store", zap.Error(err))
+	}
+	if arguments.List {
+		for _, entry := range entries {
+			logger.Info(entry.Path, zap.String("sha256", entry.SHA256), zap.String("link", entry.LinkTarget), zap.Stringer("mode", entry.Mode), zap.Time("created", entry.CreatedAt))
+		}
+		return
+	}
 
+	filePaths := arguments.FilePaths
+	if arguments.All {
+		filePaths = nil
+		for _, entry := range entries {
+			filePaths = append(filePaths, entry.Path)
+		}
+	}
+	failed := 0
+	for _, filePath := range filePaths {
+		if err := store.Restore(filePath); err != nil {
+			logger.Error("error restoring file", zap.String("file", filePath), zap.Error(err))
+			failed++
+			continue
+		}
+		logger.Info("Restored file", zap.String("file", filePath))
+	}
+	if failed > 0 {
+		logger.Fatal("some files could not be restored", zap.Int("failed", failed), zap.Int("total", len(filePaths)))
+	}
 }
 
 func CreateZapLogger() *zap.Logger {
@@ -44,8 +211,47 @@ func CreateZapLogger() *zap.Logger {
 	zapLogger := zap.New(zapcore.NewCore(
 		zapcore.NewConsoleEncoder(encoderConfig),
 		zapcore.Lock(os.Stdout),
-		zap.DebugLevel,
+		logLevel,
 	))
 
 	return zapLogger
 }
+
+func runCache(logger *zap.Logger) {
+	if len(os.Args) < 2 || cacheCommands[os.Args[1]] == nil {
+		logger.Fatal("unknown cache command, use forge cache ls or forge cache prune")
+	}
+	command := cacheCommands[os.Args[1]]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	arguments, err := forge.ParseCacheArguments()
+	if err != nil {
+		logger.Fatal("error parsing arguments", zap.Error(err))
+	}
+	cache, err := forge.OpenCache(arguments.CacheDir, arguments.CacheTTL, arguments.CacheMaxSize)
+	if err != nil {
+		logger.Fatal("error opening cache", zap.Error(err))
+	}
+	command(logger, cache, arguments)
+}
+
+func runCacheList(logger *zap.Logger, cache *forge.Cache, arguments *forge.CacheArgs) {
+	entries, err := cache.Entries()
+	if err != nil {
+		logger.Fatal("error reading cache", zap.Error(err))
+	}
+	if err := forge.WriteCacheEntries(os.Stdout, entries, arguments.JSON); err != nil {
+		logger.Fatal("error writing cache entries", zap.Error(err))
+	}
+}
+
+func runCachePrune(logger *zap.Logger, cache *forge.Cache, arguments *forge.CacheArgs) {
+	removed, err := cache.Prune(arguments.All)
+	if err != nil {
+		logger.Fatal("error pruning cache", zap.Error(err))
+	}
+	var size int64
+	for _, entry := range removed {
+		size += entry.Beacon.Size
+	}
+	logger.Info("Pruned cache", zap.Int("removed", len(removed)), zap.Int64("bytes", size))
+}
@@ -0,0 +1,121 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultPath is searched for commands inside a root filesystem
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// maxSymlinks limits how many symlinks are followed when resolving a path inside a root filesystem
+const maxSymlinks = 40
+
+// ResolveCommands resolves command names like whoami or nc to the first executable with that name
+// in the directories of pathEnv. When root is set the directories are searched inside root, and
+// DefaultPath is used if pathEnv is empty. Names containing a separator are used as paths as is.
+// Returns the resolved paths and the names that could not be resolved.
+func ResolveCommands(names []string, root string, pathEnv string) (resolved []string, unresolved []string) {
+	if pathEnv == "" && root != "" {
+		pathEnv = DefaultPath
+	}
+	dirs := filepath.SplitList(pathEnv)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.ContainsRune(name, filepath.Separator) {
+			resolved = append(resolved, filepath.Join(root, name))
+			continue
+		}
+		if path, ok := lookPath(name, root, dirs); ok {
+			resolved = append(resolved, path)
+		} else {
+			unresolved = append(unresolved, name)
+		}
+	}
+	return resolved, unresolved
+}
+
+// lookPath returns the first executable named name in dirs, searched inside root
+func lookPath(name string, root string, dirs []string) (string, bool) {
+	for _, dir := range dirs {
+		if dir == "" || !filepath.IsAbs(dir) {
+			// relative entries depend on the working directory and are ignored
+			continue
+		}
+		path := filepath.Join(root, dir, name)
+		info, err := statInRoot(root, path)
+		if err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// ErrOutsideRoot is returned for paths inside the root filesystem whose links lead outside of it
+var ErrOutsideRoot = errors.New("link resolves outside the root filesystem")
+
+// withinRoot reports whether path is root or inside it
+func withinRoot(root string, path string) bool {
+	relative, err := filepath.Rel(root, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// evalSymlinksInRoot returns path with every symlink of it resolved inside root, absolute link targets
+// are resolved relative to root. Returns ErrOutsideRoot if a link leads outside root.
+func evalSymlinksInRoot(root string, path string) (string, error) {
+	root = filepath.Clean(root)
+	relative, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil || !withinRoot(root, path) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+	}
+	resolved, pending, followed := root, strings.Split(relative, string(filepath.Separator)), 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		if !withinRoot(root, next) {
+			return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+		}
+		info, err := os.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if followed++; followed > maxSymlinks {
+			return "", &os.PathError{Op: "stat", Path: path, Err: os.ErrInvalid}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = root
+		}
+		pending = append(strings.Split(target, string(filepath.Separator)), pending...)
+	}
+	return resolved, nil
+}
+
+// statInRoot stats path following symlinks inside root, links leading outside root are rejected
+func statInRoot(root string, path string) (os.FileInfo, error) {
+	if root == "" {
+		return os.Stat(path)
+	}
+	resolved, err := evalSymlinksInRoot(root, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(resolved)
+}
@@ -0,0 +1,39 @@
+package forge
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCommands(t *testing.T) {
+	root := t.TempDir()
+	for file, mode := range map[string]os.FileMode{"usr/bin/id": 0755, "bin/busybox": 0755, "usr/bin/readme": 0644} {
+		path := filepath.Join(root, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(path, []byte(file), mode), "error should be nil")
+	}
+	assert.NoError(t, os.Symlink("/bin/busybox", filepath.Join(root, "bin/sh")), "error should be nil")
+	assert.NoError(t, os.Symlink("../usr/bin/id", filepath.Join(root, "bin/whoami")), "error should be nil")
+	host, err := os.Executable()
+	assert.NoError(t, err, "error should be nil")
+	escape, err := filepath.Rel(filepath.Join(root, "bin"), host)
+	assert.NoError(t, err, "error should be nil")
+	assert.NoError(t, os.Symlink(escape, filepath.Join(root, "bin/escape")), "error should be nil")
+
+	t.Run("ResolveCommands resolves commands inside a root filesystem", func(t *testing.T) {
+		resolved, unresolved := ResolveCommands([]string{"id", "sh", "readme", "nc"}, root, "")
+		assert.Equal(t, []string{filepath.Join(root, "usr/bin/id"), filepath.Join(root, "bin/sh")}, resolved, "executables should be resolved")
+		assert.Equal(t, []string{"readme", "nc"}, unresolved, "missing and non-executable commands should be unresolved")
+	})
+	t.Run("ResolveCommands follows relative links only inside the root filesystem", func(t *testing.T) {
+		resolved, unresolved := ResolveCommands([]string{"whoami", "escape"}, root, "")
+		assert.Equal(t, []string{filepath.Join(root, "bin/whoami")}, resolved, "links inside the root should be resolved")
+		assert.Equal(t, []string{"escape"}, unresolved, "links leaving the root should be unresolved")
+	})
+	t.Run("ResolveCommands searches the directories in order", func(t *testing.T) {
+		resolved, _ := ResolveCommands([]string{"id"}, "", filepath.Join(root, "bin")+string(filepath.ListSeparator)+filepath.Join(root, "usr/bin"))
+		assert.Equal(t, []string{filepath.Join(root, "usr/bin/id")}, resolved, "id should be resolved from the PATH")
+	})
+}
@@ -0,0 +1,114 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+)
+
+// uploadKey identifies an upload by the content of the file and the params sent with it
+type uploadKey struct {
+	sha256 string
+	params string
+}
+
+// upload is the beacon shared by every input with the same uploadKey
+type upload struct {
+	done   chan struct{}
+	binary *TempBinary
+	err    error
+}
+
+// paramsKey returns the canonical form of params
+func paramsKey(params *openapi.PostCreatorParams) string {
+	// struct fields are always marshalled in the same order
+	key, _ := json.Marshal(params)
+	return string(key)
+}
+
+// uploads are the beacons shared by the identical inputs of a run
+type uploads struct {
+	mu    sync.Mutex
+	byKey map[uploadKey]*upload
+}
+
+// newUploads returns an empty set of shared uploads, it is created per run
+func newUploads() *uploads {
+	return &uploads{byKey: map[uploadKey]*upload{}}
+}
+
+// remove removes the shared beacons once the run is done, every input has its own copy
+func (u *uploads) remove() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, shared := range u.byKey {
+		if shared.binary != nil {
+			removeTempBinary(shared.binary)
+		}
+	}
+}
+
+// sharedUpload uploads the file unless an identical file was already uploaded with the same params during the run,
+// in which case the beacon of that upload is used. Every file gets a copy of the shared beacon so no file
+// removes a beacon another file is still copying. Failed uploads are not shared with files arriving later.
+// Without uploads the file is uploaded on its own.
+func (r *Runner) sharedUpload(ctx context.Context, shared *uploads, filePath string, source Digest, target Target, params *openapi.PostCreatorParams) (*TempBinary, error) {
+	if shared == nil {
+		return r.cachedUpload(ctx, filePath, source, target, params)
+	}
+	key := uploadKey{sha256: source.SHA256, params: paramsKey(params)}
+	shared.mu.Lock()
+	current, ok := shared.byKey[key]
+	if !ok {
+		current = &upload{done: make(chan struct{})}
+		shared.byKey[key] = current
+	}
+	shared.mu.Unlock()
+	if !ok {
+		current.binary, current.err = r.cachedUpload(ctx, filePath, source, target, params)
+		if current.err != nil {
+			shared.mu.Lock()
+			delete(shared.byKey, key)
+			shared.mu.Unlock()
+		}
+		close(current.done)
+		if current.err != nil {
+			return nil, current.err
+		}
+		return r.copyTempBinary(filePath, current.binary)
+	}
+
+	select {
+	case <-current.done:
+	case <-ctx.Done():
+		return nil, &FileError{Path: filePath, Op: OpUpload, Err: ctx.Err()}
+	}
+	if current.err != nil {
+		var fileErr *FileError
+		if errors.As(current.err, &fileErr) {
+			return nil, &FileError{Path: filePath, Op: fileErr.Op, Err: fileErr.Err}
+		}
+		return nil, current.err
+	}
+	r.logger.With(zap.String("file", filePath), zap.String("identical", current.binary.originalFilePath)).Info("Reusing beacon of identical file")
+	return r.copyTempBinary(filePath, current.binary)
+}
+
+// copyTempBinary copies the beacon of binary into a temp file of its own for filePath
+func (r *Runner) copyTempBinary(filePath string, binary *TempBinary) (*TempBinary, error) {
+	beacon, err := os.Open(binary.tempFilePath.Name())
+	if err != nil {
+		return nil, &FileError{Path: filePath, Op: OpCreateTemp, Err: fmt.Errorf("error opening beacon of identical file: %w", err)}
+	}
+	defer beacon.Close()
+	copied, err := r.createTempBinaryFile(filePath, beacon)
+	if copied != nil {
+		copied.cached = binary.cached
+	}
+	return copied, err
+}
@@ -0,0 +1,95 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunner_Dedupe(t *testing.T) {
+	const beacon = "\x7fELF beacon"
+	var uploads atomic.Int32
+	handler := testCreatorHandler(beacon)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/creator" {
+			uploads.Add(1)
+		}
+		handler(w, r)
+	}))
+	defer testServer.Close()
+
+	t.Run("identical files are uploaded once and keep their own permissions", func(t *testing.T) {
+		dir := t.TempDir()
+		testFile := createTestExecutable(t, "test")
+		content, err := os.ReadFile(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+		first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+		assert.NoError(t, os.WriteFile(first, content, 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(second, content, 0700), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL})
+
+		results, err := runner.Run(context.Background(), []string{first, second})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, int32(1), uploads.Load(), "the content should be uploaded once")
+		for _, result := range results {
+			assert.Equal(t, StatusConverted, result.Status(), "every file should be converted")
+			installed, err := os.ReadFile(result.Path)
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, string(testELF(beacon)), string(installed), "every file should be a beacon")
+		}
+		firstInfo, err := os.Stat(first)
+		assert.NoError(t, err, "error should be nil")
+		secondInfo, err := os.Stat(second)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, os.FileMode(0755), firstInfo.Mode().Perm(), "first should keep its permissions")
+		assert.Equal(t, os.FileMode(0700), secondInfo.Mode().Perm(), "second should keep its permissions")
+		assert.False(t, os.SameFile(firstInfo, secondInfo), "the beacons should be separate files")
+	})
+	t.Run("uploads are not shared between runs", func(t *testing.T) {
+		uploads.Store(0)
+		testFile := createTestExecutable(t, "between runs")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir()})
+
+		for run := 0; run < 2; run++ {
+			results, err := runner.Run(context.Background(), []string{testFile.Name()})
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, StatusConverted, results[0].Status(), "the file should be converted in every run")
+		}
+		assert.Equal(t, int32(2), uploads.Load(), "every run should upload the file")
+	})
+}
+
+func TestRunner_DedupeFailure(t *testing.T) {
+	var uploads atomic.Int32
+	handler := testCreatorHandler("\x7fELF beacon")
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/creator" && uploads.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler(w, r)
+	}))
+	defer testServer.Close()
+
+	t.Run("a failed upload is not shared with identical files processed later", func(t *testing.T) {
+		dir := t.TempDir()
+		testFile := createTestExecutable(t, "test")
+		content, err := os.ReadFile(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+		first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+		assert.NoError(t, os.WriteFile(first, content, 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(second, content, 0755), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Concurrency: 1, ContinueOnError: true, SkipPreflight: true})
+
+		results, err := runner.Run(context.Background(), []string{first, second})
+		assert.Error(t, err, "error should not be nil")
+		assert.Equal(t, StatusFailed, results[0].Status(), "the first upload should fail")
+		assert.Equal(t, StatusConverted, results[1].Status(), "the second file should be uploaded again")
+		assert.Equal(t, int32(2), uploads.Load(), "the content should be uploaded twice")
+	})
+}
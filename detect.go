@@ -0,0 +1,126 @@
+package forge
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// ErrUnknownFormat is returned when a file is not an ELF, Mach-O or PE executable
+var ErrUnknownFormat = errors.New("unknown executable format")
+
+// Target is the operating system and architecture a binary runs on, using GOOS and GOARCH names
+type Target struct {
+	Os   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+func (t Target) String() string {
+	return t.Os + "/" + t.Arch
+}
+
+var elfArchs = map[elf.Machine]string{
+	elf.EM_386:     "386",
+	elf.EM_X86_64:  "amd64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_MIPS:    "mips",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_S390:    "s390x",
+}
+
+var elfOses = map[elf.OSABI]string{
+	elf.ELFOSABI_FREEBSD: "freebsd",
+	elf.ELFOSABI_NETBSD:  "netbsd",
+	elf.ELFOSABI_OPENBSD: "openbsd",
+	elf.ELFOSABI_SOLARIS: "solaris",
+}
+
+var machoArchs = map[macho.Cpu]string{
+	macho.Cpu386:   "386",
+	macho.CpuAmd64: "amd64",
+	macho.CpuArm:   "arm",
+	macho.CpuArm64: "arm64",
+	macho.CpuPpc64: "ppc64",
+}
+
+var peArchs = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+}
+
+// DetectTarget inspects the headers of the executable at path and returns the target it was built for.
+// Universal Mach-O binaries resolve to the host architecture when included, otherwise to their first architecture.
+func DetectTarget(path string) (Target, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Target{}, err
+	}
+	defer file.Close()
+	if elfFile, err := elf.NewFile(file); err == nil {
+		return elfTarget(elfFile)
+	}
+	if machoFile, err := macho.NewFile(file); err == nil {
+		return machoTarget(machoFile.Cpu)
+	}
+	if fatFile, err := macho.NewFatFile(file); err == nil {
+		return fatMachoTarget(fatFile)
+	}
+	if peFile, err := pe.NewFile(file); err == nil {
+		return peTarget(peFile)
+	}
+	return Target{}, ErrUnknownFormat
+}
+
+func elfTarget(file *elf.File) (Target, error) {
+	arch, ok := elfArchs[file.Machine]
+	if !ok {
+		return Target{}, fmt.Errorf("unsupported ELF machine: %s", file.Machine)
+	}
+	if arch == "mips" && file.Class == elf.ELFCLASS64 {
+		arch = "mips64"
+	}
+	if (arch == "mips" || arch == "mips64" || arch == "ppc64") && file.Data == elf.ELFDATA2LSB {
+		arch += "le"
+	}
+	goos, ok := elfOses[file.OSABI]
+	if !ok {
+		goos = "linux"
+	}
+	return Target{Os: goos, Arch: arch}, nil
+}
+
+func machoTarget(cpu macho.Cpu) (Target, error) {
+	arch, ok := machoArchs[cpu]
+	if !ok {
+		return Target{}, fmt.Errorf("unsupported Mach-O cpu: %s", cpu)
+	}
+	return Target{Os: "darwin", Arch: arch}, nil
+}
+
+func fatMachoTarget(file *macho.FatFile) (Target, error) {
+	if len(file.Arches) == 0 {
+		return Target{}, ErrUnknownFormat
+	}
+	for _, arch := range file.Arches {
+		if target, err := machoTarget(arch.Cpu); err == nil && target.Arch == runtime.GOARCH {
+			return target, nil
+		}
+	}
+	return machoTarget(file.Arches[0].Cpu)
+}
+
+func peTarget(file *pe.File) (Target, error) {
+	arch, ok := peArchs[file.Machine]
+	if !ok {
+		return Target{}, fmt.Errorf("unsupported PE machine: %#x", file.Machine)
+	}
+	return Target{Os: "windows", Arch: arch}, nil
+}
@@ -0,0 +1,29 @@
+package forge
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDetectTarget(t *testing.T) {
+	t.Run("DetectTarget detects ELF executables", func(t *testing.T) {
+		testFile := createTestExecutable(t, "elf")
+		defer os.Remove(testFile.Name())
+		target, err := DetectTarget(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, Target{Os: "linux", Arch: "amd64"}, target, "target should be linux/amd64")
+	})
+	t.Run("DetectTarget detects universal Mach-O executables", func(t *testing.T) {
+		target, err := DetectTarget("testFiles/ls_darwin")
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "darwin", target.Os, "os should be darwin")
+		assert.Contains(t, []string{"amd64", "arm64"}, target.Arch, "arch should be one of the included architectures")
+	})
+	t.Run("DetectTarget returns ErrUnknownFormat for other files", func(t *testing.T) {
+		testFile := createAndWriteTempFile(t, "script")
+		defer os.Remove(testFile.Name())
+		_, err := DetectTarget(testFile.Name())
+		assert.ErrorIs(t, err, ErrUnknownFormat, "error should be ErrUnknownFormat")
+	})
+}
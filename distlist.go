@@ -0,0 +1,116 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrUnsupportedTarget is returned when the creator cannot build beacons for the target of a file
+var ErrUnsupportedTarget = errors.New("unsupported target")
+
+// targetAliases maps the names used by the creator to GOOS and GOARCH names
+var targetAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"macos":   "darwin",
+}
+
+func normalizeTargetName(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := targetAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// Distlist returns the targets supported by the creator, the list is cached by the Runner once it was fetched
+// successfully. Errors are not cached so the next call fetches the list again.
+func (r *Runner) Distlist(ctx context.Context) ([]Target, error) {
+	r.distlistMu.Lock()
+	defer r.distlistMu.Unlock()
+	if r.distlist != nil {
+		return r.distlist, nil
+	}
+	targets, err := r.fetchDistlist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.distlist = targets
+	return targets, nil
+}
+
+func (r *Runner) fetchDistlist(ctx context.Context) ([]Target, error) {
+	response, err := r.client.GetCreatorDistlist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching distlist: %w", err)
+	}
+	body, err := r.checkResponseStatus(response)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching distlist: %w", err)
+	}
+	defer body.Close()
+	return decodeDistlist(body)
+}
+
+func decodeDistlist(body io.Reader) ([]Target, error) {
+	var dists []openapi.Dist
+	if err := json.NewDecoder(body).Decode(&dists); err != nil {
+		return nil, fmt.Errorf("error decoding distlist: %w", err)
+	}
+	targets := make([]Target, 0, len(dists))
+	for _, dist := range dists {
+		if dist.Os == nil || dist.Arch == nil {
+			continue
+		}
+		targets = append(targets, Target{Os: normalizeTargetName(*dist.Os), Arch: normalizeTargetName(*dist.Arch)})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+	return targets, nil
+}
+
+// checkTarget returns an error if the target of filePath is not in the distlist of the creator
+func (r *Runner) checkTarget(ctx context.Context, filePath string, target Target) error {
+	if r.opts.SkipPreflight {
+		return nil
+	}
+	targets, err := r.Distlist(ctx)
+	if err != nil {
+		return &FileError{Path: filePath, Op: OpPreflight, Err: err}
+	}
+	normalized := Target{Os: normalizeTargetName(target.Os), Arch: normalizeTargetName(target.Arch)}
+	supported := make([]string, len(targets))
+	for i, t := range targets {
+		if t == normalized {
+			return nil
+		}
+		supported[i] = t.String()
+	}
+	return &FileError{Path: filePath, Op: OpPreflight, Err: fmt.Errorf("%w %s, supported targets: %s", ErrUnsupportedTarget, target, strings.Join(supported, ", "))}
+}
+
+// WriteDistlist writes the targets as a table, or as a JSON array if asJSON is set
+func WriteDistlist(w io.Writer, targets []Target, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(targets)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "OS\tARCH")
+	for _, target := range targets {
+		fmt.Fprintf(table, "%s\t%s\n", target.Os, target.Arch)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,114 @@
+package forge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrNoFiles is returned when there are no files to convert
+	ErrNoFiles = errors.New("no files provided")
+	// ErrInvalidGroupId is returned when the group id of the beacon is not a valid UUID
+	ErrInvalidGroupId = errors.New("invalid group id")
+	// ErrInPlaceOutput is returned when both in-place mode and an output folder are requested
+	ErrInPlaceOutput = errors.New("--in-place and --output are mutually exclusive")
+	// ErrInvalidConcurrency is returned for a negative concurrency
+	ErrInvalidConcurrency = errors.New("concurrency must not be negative")
+	// ErrAborted is set on files that were not installed because another file of the batch failed
+	ErrAborted = errors.New("aborted due to an error in another file")
+)
+
+// Operations reported by FileError
+const (
+	OpBackup      = "backup"
+	OpDetect      = "detect"
+	OpOpen        = "open"
+	OpPreflight   = "preflight"
+	OpUpload      = "upload"
+	OpCreateTemp  = "create temp"
+	OpPermissions = "permissions"
+	OpMkdir       = "mkdir"
+	OpRename      = "rename"
+	OpLink        = "link"
+	OpDestination = "destination"
+	OpValidate    = "validate"
+	OpSmokeTest   = "smoke test"
+	OpGuard       = "guard"
+)
+
+// FileError records the failure of a single file and the operation that failed
+type FileError struct {
+	Path string
+	Op   string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ErrCodeInvalidRequest is the ErrorMsg code returned by the creator for invalid request parameters
+const ErrCodeInvalidRequest = "invalid_request"
+
+// maxErrorBodySize limits how much of an error response is read
+const maxErrorBodySize = 64 * 1024
+
+// APIError is returned when the creator responds with an unexpected status,
+// Code and Message are decoded from the ErrorMsg body of the response when present
+type APIError struct {
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+	// RetryAfter is the delay requested by the Retry-After header, zero if not set
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" && e.Message == "" {
+		return fmt.Sprintf("unexpected response status: %s", e.Status)
+	}
+	return fmt.Sprintf("unexpected response status: %s, %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsClientError reports whether the request was rejected by the creator, retrying it will fail again
+func (e *APIError) IsClientError() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// IsServerError reports whether the creator or the gateway failed to handle the request
+func (e *APIError) IsServerError() bool {
+	return e.StatusCode >= 500
+}
+
+// newAPIError creates the error for a response with an unexpected status, decoding its ErrorMsg body
+func newAPIError(response *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+	}
+	var errorMsg openapi.ErrorMsg
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxErrorBodySize)).Decode(&errorMsg); err == nil {
+		apiErr.Code, apiErr.Message = errorMsg.Code, errorMsg.Message
+	}
+	return apiErr
+}
+
+// ErrorCode returns the ErrorMsg code of the creator if err was caused by an error response, empty otherwise
+func ErrorCode(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// Options configures how beacons are created and where they are written
+type Options struct {
+	// CreatorUrl is the address of the gateway serving the creator API
+	CreatorUrl string
+	// OutputFolder is the folder the beacons are written to, the original files are overwritten when empty
+	OutputFolder string
+	// Credentials authenticate the requests to the gateway, completed from the FORGE_TOKEN and
+	// FORGE_API_KEY environment variables and the credentials file when not set
+	Credentials Credentials
+	// CredentialsPath is the JSON credentials file, DefaultCredentialsPath is used when empty
+	CredentialsPath string
+	// BackupDir is the backup store for the originals of overwritten files, DefaultBackupDir is used when empty
+	BackupDir string
+	// NoBackup disables keeping the originals of overwritten files
+	NoBackup bool
+	// BeaconOpts configures the beacons, Os and Arch are only used when ForceTarget is set
+	BeaconOpts BeaconOptions
+	// ForceTarget uses the Os and Arch of BeaconOpts for every file instead of detecting them from the file headers
+	ForceTarget bool
+	// SkipPreflight disables checking the target of every file against the distlist of the creator before uploading
+	SkipPreflight bool
+	// Concurrency is the maximum number of files processed at the same time, GOMAXPROCS when zero
+	Concurrency int
+	// Rate is the maximum number of uploads started per second, unlimited when zero
+	Rate float64
+	// Retries is the number of times a failed upload is retried, only connection failures and
+	// 429, 502, 503 and 504 responses are retried
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled for every following retry
+	RetryBackoff time.Duration
+	// RetryMaxBackoff caps the delay between retries
+	RetryMaxBackoff time.Duration
+	// ManifestPath is where the JSON manifest of the run is written, no manifest is written when empty
+	ManifestPath string
+	// Preserve are the attributes of the originals preserved on their beacons besides the mode,
+	// any of PreserveOwner, PreserveTimestamps, PreserveXattrs or PreserveAll
+	Preserve []string
+	// Layout is how beacons are placed in the output folder, LayoutFlat or LayoutTree.
+	// LayoutFlat is used when empty.
+	Layout string
+	// CacheDir is the folder where beacons are cached, no cache is used when empty
+	CacheDir string
+	// NoCache disables the cache
+	NoCache bool
+	// CacheTTL is how long cached beacons are used, 0 for no limit
+	CacheTTL time.Duration
+	// CacheMaxSize is the size in bytes the cache is pruned to, 0 for no limit
+	CacheMaxSize int64
+	// LinkPolicy is how symbolic and hard links are handled, one of LinkTarget, LinkReplace or LinkSkip.
+	// LinkTarget is used when empty.
+	LinkPolicy string
+	// Root is the root filesystem the inputs inside it belong to, their symlinks are resolved inside it
+	// with absolute targets relative to Root and links leaving it are rejected
+	Root string
+	// PreviousManifest is the manifest of a previous run, files matching a beacon recorded in it are skipped
+	// and its beacons for files not processed again are carried forward into the manifest of the run.
+	// ManifestPath is used when empty and the file exists.
+	PreviousManifest string
+	// SmokeTest runs every beacon built for the host os and arch next to its original in a scratch folder
+	// and rejects it if the exit code or stdout differ
+	SmokeTest bool
+	// SmokeArgs are the arguments both executables are run with in the smoke test, e.g. --version
+	SmokeArgs []string
+	// SmokeTimeout limits every run of the smoke test, DefaultSmokeTimeout is used when zero
+	SmokeTimeout time.Duration
+	// Force converts protected system files like dynamic loaders, libc, init, package managers and forge itself
+	Force bool
+	// ContinueOnError processes every file independently instead of aborting the batch on the first failure
+	ContinueOnError bool
+}
+
+// Result is the outcome of converting a single file
+type Result struct {
+	// Path is the file that was converted
+	Path string
+	// Destination is where the beacon was written, empty if it was not installed
+	Destination string
+	// Backup is the copy of the original file kept when it was overwritten
+	Backup string
+	// Unpreserved are the attributes of the original that could not be preserved on the beacon
+	Unpreserved []string
+	// Warnings are the reasons to be careful with replacing the original, e.g. it was being executed
+	Warnings []string
+	// SkipReason is set when the file was deliberately not converted, Err is nil in that case
+	SkipReason string
+	// Target is the os and arch the beacon was built for
+	Target Target
+	// Params are the parameters sent to the creator
+	Params *openapi.PostCreatorParams
+	// Source is the digest of the original file
+	Source Digest
+	// Beacon is the digest of the beacon returned by the creator
+	Beacon Digest
+	// InstalledAt is when the beacon was written to its destination
+	InstalledAt time.Time
+	// Err is nil when the beacon was installed, otherwise it is a *FileError or ErrAborted
+	Err error
+}
+
+// Modes of a run
+const (
+	// ModeInPlace overwrites the original files with their beacons
+	ModeInPlace = "in-place"
+	// ModeOutputFolder writes the beacons to the output folder and leaves the originals untouched
+	ModeOutputFolder = "output-folder"
+)
+
+// Mode returns ModeInPlace when no output folder is set, ModeOutputFolder otherwise
+func (o *Options) Mode() string {
+	if o.OutputFolder == "" {
+		return ModeInPlace
+	}
+	return ModeOutputFolder
+}
+
+// describeMode returns the mode together with where the beacons or the originals end up
+func (o *Options) describeMode() string {
+	switch {
+	case o.OutputFolder != "":
+		return fmt.Sprintf("%s %s", ModeOutputFolder, o.OutputFolder)
+	case o.NoBackup:
+		return fmt.Sprintf("%s, originals not backed up", ModeInPlace)
+	}
+	backupDir := o.BackupDir
+	if backupDir == "" {
+		backupDir = DefaultBackupDir()
+	}
+	return fmt.Sprintf("%s, originals backed up to %s", ModeInPlace, backupDir)
+}
+
+// previousManifest returns the manifest of the previous run, empty if there is none
+func (o *Options) previousManifest() string {
+	if o.PreviousManifest != "" {
+		return o.PreviousManifest
+	}
+	if o.ManifestPath != "" {
+		if _, err := os.Stat(o.ManifestPath); err == nil {
+			return o.ManifestPath
+		}
+	}
+	return ""
+}
+
+// Forge converts binaries into beacons, it never exits the process and can be embedded in other programs
+type Forge struct {
+	runner *Runner
+}
+
+// New creates a Forge from the given options, a nil logger disables logging
+func New(logger *zap.Logger, opts Options) (*Forge, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	runner, err := NewRunner(logger, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Forge{runner: runner}, nil
+}
+
+// Forge converts the given files into beacons and returns the result of every file,
+// in the same order as files. The returned error is non-nil if any file failed,
+// the results are returned in that case as well to report which files were converted.
+func (f *Forge) Forge(ctx context.Context, files []string) ([]*Result, error) {
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+	return f.runner.Run(ctx, files)
+}
+
+// Plan returns what Forge would do with the files without uploading them or changing any file
+func (f *Forge) Plan(ctx context.Context, files []string) ([]*PlanEntry, error) {
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+	return f.runner.Plan(ctx, files)
+}
+
+// Distlist returns the os and arch combinations supported by the creator
+func (f *Forge) Distlist(ctx context.Context) ([]Target, error) {
+	return f.runner.Distlist(ctx)
+}
@@ -0,0 +1,217 @@
+package forge
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// isolateEnv points HOME at a temp dir and clears the credential variables, so neither the default stores
+// nor the credentials of the user running the tests are used
+func isolateEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(TokenEnv, "")
+	t.Setenv(APIKeyEnv, "")
+}
+
+func TestNew(t *testing.T) {
+	t.Run("New returns ErrInvalidGroupId for a malformed group id", func(t *testing.T) {
+		isolateEnv(t)
+		_, err := New(nil, Options{BeaconOpts: BeaconOptions{GroupId: "not-a-uuid"}})
+		assert.ErrorIs(t, err, ErrInvalidGroupId, "error should be ErrInvalidGroupId")
+	})
+	t.Run("New returns ErrInvalidConcurrency for a negative concurrency", func(t *testing.T) {
+		isolateEnv(t)
+		_, err := New(nil, Options{Concurrency: -1})
+		assert.ErrorIs(t, err, ErrInvalidConcurrency, "error should be ErrInvalidConcurrency")
+	})
+}
+
+func TestForge_Forge(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("test"))
+	defer testServer.Close()
+
+	t.Run("Forge returns ErrNoFiles without files", func(t *testing.T) {
+		isolateEnv(t)
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, BackupDir: t.TempDir()})
+		assert.NoError(t, err, "error should be nil")
+		_, err = f.Forge(context.Background(), nil)
+		assert.ErrorIs(t, err, ErrNoFiles, "error should be ErrNoFiles")
+	})
+
+	t.Run("Forge returns a FileError per failed file and aborts the batch", func(t *testing.T) {
+		outdir := t.TempDir()
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		missing := filepath.Join(outdir, "missing")
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name(), missing})
+		assert.Error(t, err, "error should not be nil")
+		assert.Len(t, results, 2, "there should be a result for every file")
+		assert.ErrorIs(t, results[0].Err, ErrAborted, "the valid file should be aborted")
+		var fileErr *FileError
+		assert.True(t, errors.As(results[1].Err, &fileErr), "error should be a FileError")
+		assert.Equal(t, OpOpen, fileErr.Op, "open should be the failed operation")
+		assert.Equal(t, missing, fileErr.Path, "path should be the missing file")
+		assert.NoFileExists(t, filepath.Join(outdir, filepath.Base(testFile.Name())), "nothing should be installed")
+	})
+}
+
+func TestForge_ContinueOnError(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("test"))
+	defer testServer.Close()
+
+	t.Run("Forge installs the valid files when another file fails", func(t *testing.T) {
+		outdir := t.TempDir()
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, ContinueOnError: true})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		missing := filepath.Join(outdir, "missing")
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name(), missing})
+		assert.Error(t, err, "error should not be nil")
+		assert.NoError(t, results[0].Err, "the valid file should be converted")
+		assert.Equal(t, StatusConverted, results[0].Status(), "the valid file should be converted")
+		assert.FileExists(t, results[0].Destination, "the valid file should be installed")
+		assert.Equal(t, StatusFailed, results[1].Status(), "the missing file should fail")
+
+		var summary strings.Builder
+		assert.NoError(t, WriteSummary(&summary, results, &Options{OutputFolder: outdir}), "error should be nil")
+		assert.Contains(t, summary.String(), "1 converted, 0 skipped, 1 not converted, 2 total", "summary should contain the totals")
+		assert.Contains(t, summary.String(), "mode: output-folder "+outdir, "summary should contain the mode")
+		assert.Contains(t, summary.String(), missing, "summary should list the failed file")
+	})
+
+	t.Run("Forge reports the HTTP status of a failed upload", func(t *testing.T) {
+		failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer failingServer.Close()
+		f, err := New(nil, Options{CreatorUrl: failingServer.URL, OutputFolder: t.TempDir(), ContinueOnError: true, SkipPreflight: true})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name()})
+		assert.Error(t, err, "error should not be nil")
+		var apiErr *APIError
+		assert.True(t, errors.As(results[0].Err, &apiErr), "error should be an APIError")
+		assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode, "status code should be reported")
+	})
+}
+
+func TestForge_Distlist(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("test"))
+	defer testServer.Close()
+
+	t.Run("Distlist normalizes the targets of the creator", func(t *testing.T) {
+		isolateEnv(t)
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, BackupDir: t.TempDir()})
+		assert.NoError(t, err, "error should be nil")
+		targets, err := f.Distlist(context.Background())
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, []Target{{Os: "darwin", Arch: "arm64"}, {Os: "linux", Arch: "amd64"}}, targets, "targets should be normalized and sorted")
+	})
+
+	t.Run("Forge rejects files with an unsupported target before uploading", func(t *testing.T) {
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), ForceTarget: true, BeaconOpts: BeaconOptions{Os: "windows", Arch: "amd64"}})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name()})
+		assert.ErrorIs(t, err, ErrUnsupportedTarget, "error should be ErrUnsupportedTarget")
+		assert.Contains(t, results[0].Cause(), "darwin/arm64, linux/amd64", "cause should list the supported targets")
+	})
+
+	t.Run("Distlist fetches the list again after an error and caches it once fetched", func(t *testing.T) {
+		var fetches atomic.Int32
+		handler := testCreatorHandler("test")
+		flakyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fetches.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			handler(w, r)
+		}))
+		defer flakyServer.Close()
+		isolateEnv(t)
+		f, err := New(nil, Options{CreatorUrl: flakyServer.URL, BackupDir: t.TempDir()})
+		assert.NoError(t, err, "error should be nil")
+
+		_, err = f.Distlist(context.Background())
+		assert.Error(t, err, "the first fetch should fail")
+		for i := 0; i < 2; i++ {
+			targets, err := f.Distlist(context.Background())
+			assert.NoError(t, err, "error should be nil")
+			assert.Len(t, targets, 2, "the targets should be returned")
+		}
+		assert.Equal(t, int32(2), fetches.Load(), "the list should be fetched again once and then cached")
+	})
+}
+
+func TestForge_Manifest(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("test"))
+	defer testServer.Close()
+
+	t.Run("Forge writes a manifest that can be read back", func(t *testing.T) {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), ManifestPath: manifestPath, BeaconOpts: BeaconOptions{Transport: "dns"}})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		source, err := digestFile(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name()})
+		assert.NoError(t, err, "error should be nil")
+
+		manifest, err := ReadManifest(manifestPath)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, Version, manifest.ForgeVersion, "manifest should contain the forge version")
+		assert.Len(t, manifest.Entries, 1, "manifest should contain every file")
+		entry := manifest.Entries[0]
+		assert.Equal(t, results[0].Destination, entry.Destination, "manifest should contain the destination")
+		assert.Equal(t, source, entry.Before, "manifest should contain the digest of the original")
+		assert.Equal(t, int64(len(testELF("test"))), entry.After.Size, "manifest should contain the digest of the beacon")
+		assert.Equal(t, Target{Os: "linux", Arch: "amd64"}, entry.Target, "manifest should contain the target")
+		assert.Equal(t, "dns", *entry.Params.Transport, "manifest should contain the params sent")
+		assert.NotNil(t, entry.InstalledAt, "manifest should contain the installation time")
+	})
+}
+
+func TestForge_ErrorMsg(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"invalid_request","message":"Invalid request parameters"}`))
+	}))
+	defer testServer.Close()
+
+	t.Run("Forge decodes the ErrorMsg of the creator", func(t *testing.T) {
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), SkipPreflight: true})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+
+		results, err := f.Forge(context.Background(), []string{testFile.Name()})
+		assert.Error(t, err, "error should not be nil")
+		assert.Equal(t, ErrCodeInvalidRequest, ErrorCode(err), "code should be available from the error")
+		var apiErr *APIError
+		assert.True(t, errors.As(results[0].Err, &apiErr), "error should be an APIError")
+		assert.True(t, apiErr.IsClientError(), "400 should be a client error")
+		assert.Equal(t, "Invalid request parameters", apiErr.Message, "message should be decoded")
+		assert.Contains(t, results[0].Cause(), "Invalid request parameters", "cause should contain the message")
+	})
+}
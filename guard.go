@@ -0,0 +1,171 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrProtected is returned for critical system files unless Force is set
+var ErrProtected = errors.New("protected system file")
+
+// protectedFiles are the name patterns of the files that can break the system when replaced, by kind
+var protectedFiles = []struct {
+	kind     string
+	patterns []string
+}{
+	{"dynamic loader", []string{"ld-linux*.so*", "ld-musl-*.so*", "ld64.so*", "ld.so*", "ld-[0-9]*.so", "dyld"}},
+	{"libc", []string{"libc.so*", "libc-[0-9]*.so", "libc.musl-*.so*"}},
+	{"init", []string{"init", "systemd", "openrc-init", "runit-init", "tini", "dumb-init"}},
+	{"package manager", []string{"apk", "apt", "apt-get", "dpkg", "rpm", "yum", "dnf", "microdnf", "pacman", "zypper"}},
+}
+
+// criticalPaths are the files the system cannot run without whatever they are named,
+// e.g. busybox is both /sbin/init and /bin/sh on Alpine
+var criticalPaths = []struct {
+	kind string
+	path string
+}{
+	{"init", "/sbin/init"},
+	{"shell", "/bin/sh"},
+}
+
+// protectedKind returns what kind of protected file path is, empty if it is not protected.
+// The name of the file, the name of the file its links resolve to, whether path is a critical path, links included,
+// and whether the file is what a critical path resolves to are checked. Files inside root are resolved inside root,
+// where the critical paths of root apply too.
+func protectedKind(root string, path string) string {
+	if isRunningExecutable(path) {
+		return "the running forge binary"
+	}
+	names := []string{filepath.Base(path)}
+	if resolved, err := resolvePath(root, path); err == nil {
+		names = append(names, filepath.Base(resolved))
+	}
+	for _, protected := range protectedFiles {
+		for _, pattern := range protected.patterns {
+			for _, name := range names {
+				if matched, _ := filepath.Match(pattern, name); matched {
+					return protected.kind
+				}
+			}
+		}
+	}
+	// other links are replaced by their own beacon, only the critical paths and the files they resolve to are protected
+	location := linkLocation(root, path)
+	info, err := os.Lstat(path)
+	for _, critical := range criticalPaths {
+		candidates := []string{critical.path}
+		if root != "" {
+			candidates = append(candidates, filepath.Join(root, critical.path))
+		}
+		for _, candidate := range candidates {
+			if location == linkLocation(root, candidate) {
+				return critical.kind
+			}
+			if err != nil {
+				continue
+			}
+			resolved, err := resolvePath(root, candidate)
+			if err != nil {
+				continue
+			}
+			if target, err := os.Stat(resolved); err == nil && os.SameFile(info, target) {
+				return critical.kind
+			}
+		}
+	}
+	return ""
+}
+
+// linkLocation returns path with the links of its folder resolved, the path of the file or link itself.
+// /bin/sh and /usr/bin/sh have the same location when /bin links to /usr/bin.
+func linkLocation(root string, path string) string {
+	dir, err := resolvePath(root, filepath.Dir(path))
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, filepath.Base(path))
+}
+
+// resolvePath resolves the links of path, inside root if path is inside it
+func resolvePath(root string, path string) (string, error) {
+	if root != "" && withinRoot(root, path) {
+		return evalSymlinksInRoot(root, path)
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// isRunningExecutable reports whether path is the executable of the current process
+func isRunningExecutable(path string) bool {
+	executable, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	self, err := os.Stat(executable)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && os.SameFile(self, info)
+}
+
+// guard returns a *FileError wrapping ErrProtected if path is protected and Force is not set
+func (r *Runner) guard(path string) error {
+	if r.opts.Force {
+		return nil
+	}
+	if kind := protectedKind(r.opts.Root, path); kind != "" {
+		return &FileError{Path: path, Op: OpGuard, Err: fmt.Errorf("%w (%s), use --force to convert it anyway", ErrProtected, kind)}
+	}
+	return nil
+}
+
+// warnings returns the reasons to be careful replacing path in place: it is currently being executed
+// or it is owned by another user. There are no warnings when the originals are left untouched.
+func (r *Runner) warnings(path string) []string {
+	if r.opts.Mode() != ModeInPlace {
+		return nil
+	}
+	var warnings []string
+	if r.isExecuting(path) {
+		warnings = append(warnings, "currently being executed, running processes keep the original")
+	}
+	if info, err := os.Stat(path); err == nil {
+		if uid, _, ok := fileOwner(info); ok && uid != os.Geteuid() {
+			warnings = append(warnings, fmt.Sprintf("owned by another user (uid %d)", uid))
+		}
+	}
+	return warnings
+}
+
+// isExecuting reports whether path is the executable of a running process. The file is never opened,
+// so planning a run does not open every input for writing.
+func (r *Runner) isExecuting(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	id, _, ok := fileIdentity(info)
+	return ok && r.runningExecutables()[id]
+}
+
+// runningExecutables returns the files the processes in /proc are executing, empty where there is no /proc.
+// Processes of other users are only visible with the privileges to replace their executables.
+func (r *Runner) runningExecutables() map[fileID]bool {
+	r.runningOnce.Do(func() {
+		r.running = map[fileID]bool{}
+		executables, _ := filepath.Glob("/proc/[0-9]*/exe")
+		for _, executable := range executables {
+			info, err := os.Stat(executable)
+			if err != nil {
+				continue
+			}
+			if id, _, ok := fileIdentity(info); ok {
+				r.running[id] = true
+			}
+		}
+	})
+	return r.running
+}
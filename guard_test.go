@@ -0,0 +1,165 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestProtectedKind(t *testing.T) {
+	t.Run("protectedKind recognizes critical system files by name", func(t *testing.T) {
+		assert.Equal(t, "dynamic loader", protectedKind("", "/lib/ld-musl-x86_64.so.1"), "musl loader should be protected")
+		assert.Equal(t, "dynamic loader", protectedKind("", "/lib64/ld-linux-x86-64.so.2"), "glibc loader should be protected")
+		assert.Equal(t, "libc", protectedKind("", "/lib/x86_64-linux-gnu/libc.so.6"), "libc should be protected")
+		assert.Equal(t, "init", protectedKind("", "/sbin/init"), "init should be protected")
+		assert.Equal(t, "package manager", protectedKind("", "/sbin/apk"), "apk should be protected")
+		assert.Empty(t, protectedKind("", "/bin/ls"), "ls should not be protected")
+	})
+	t.Run("protectedKind recognizes the running executable", func(t *testing.T) {
+		executable, err := os.Executable()
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "the running forge binary", protectedKind("", executable), "the running executable should be protected")
+	})
+	t.Run("protectedKind checks the file a link resolves to", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "dpkg"), testELF("dpkg"), 0755), "error should be nil")
+		assert.NoError(t, os.Symlink("dpkg", filepath.Join(dir, "installer")), "error should be nil")
+		assert.Equal(t, "package manager", protectedKind("", filepath.Join(dir, "installer")), "the link target should be checked")
+	})
+	t.Run("protectedKind recognizes the file init and sh resolve to inside the root", func(t *testing.T) {
+		root := t.TempDir()
+		for _, dir := range []string{"bin", "sbin"} {
+			assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755), "error should be nil")
+		}
+		busybox := filepath.Join(root, "bin", "busybox")
+		assert.NoError(t, os.WriteFile(busybox, testELF("busybox"), 0755), "error should be nil")
+		for _, link := range []string{"sbin/init", "bin/sh", "bin/echo"} {
+			assert.NoError(t, os.Symlink("/bin/busybox", filepath.Join(root, link)), "error should be nil")
+		}
+		assert.Equal(t, "init", protectedKind(root, busybox), "busybox should be protected as init")
+		assert.Empty(t, protectedKind(root, filepath.Join(root, "bin", "echo")), "a link replaced by its own beacon should not be protected")
+		assert.Equal(t, "shell", protectedKind(root, filepath.Join(root, "bin", "sh")), "the sh link itself should be protected")
+	})
+	t.Run("protectedKind recognizes critical paths reached through a linked folder", func(t *testing.T) {
+		root := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, "usr", "bin"), 0755), "error should be nil")
+		assert.NoError(t, os.Symlink("usr/bin", filepath.Join(root, "bin")), "error should be nil")
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "usr", "bin", "dash"), testELF("dash"), 0755), "error should be nil")
+		assert.NoError(t, os.Symlink("dash", filepath.Join(root, "usr", "bin", "sh")), "error should be nil")
+		assert.Equal(t, "shell", protectedKind(root, filepath.Join(root, "usr", "bin", "sh")), "sh should be protected through the linked folder")
+	})
+}
+
+func TestRunner_Guard(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("test"))
+	defer testServer.Close()
+
+	t.Run("Run refuses protected files without Force", func(t *testing.T) {
+		apk := filepath.Join(t.TempDir(), "apk")
+		assert.NoError(t, os.WriteFile(apk, testELF("apk"), 0755), "error should be nil")
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir})
+
+		results, err := runner.Run(context.Background(), []string{apk})
+		assert.ErrorIs(t, err, ErrProtected, "error should be ErrProtected")
+		assert.Contains(t, results[0].Cause(), "package manager", "cause should contain the kind of file")
+		assert.NoFileExists(t, filepath.Join(outdir, "apk"), "nothing should be installed")
+	})
+	t.Run("Run converts protected files with Force", func(t *testing.T) {
+		apk := filepath.Join(t.TempDir(), "apk")
+		assert.NoError(t, os.WriteFile(apk, testELF("apk"), 0755), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Force: true})
+
+		results, err := runner.Run(context.Background(), []string{apk})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, StatusConverted, results[0].Status(), "the file should be converted")
+	})
+	t.Run("Run refuses busybox when init resolves to it inside the root", func(t *testing.T) {
+		root := t.TempDir()
+		for _, dir := range []string{"bin", "sbin"} {
+			assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755), "error should be nil")
+		}
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "bin", "busybox"), testELF("busybox"), 0755), "error should be nil")
+		assert.NoError(t, os.Symlink("/bin/busybox", filepath.Join(root, "sbin", "init")), "error should be nil")
+		assert.NoError(t, os.Symlink("/bin/busybox", filepath.Join(root, "bin", "echo")), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Root: root})
+
+		_, err := runner.Run(context.Background(), []string{filepath.Join(root, "bin", "echo")})
+		assert.ErrorIs(t, err, ErrProtected, "converting the target of echo should replace init")
+	})
+	t.Run("Run refuses to replace the sh link with the replace policy", func(t *testing.T) {
+		root := t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, "bin"), 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "bin", "busybox"), testELF("busybox"), 0755), "error should be nil")
+		assert.NoError(t, os.Symlink("busybox", filepath.Join(root, "bin", "sh")), "error should be nil")
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, Root: root, LinkPolicy: LinkReplace})
+
+		assert.ErrorIs(t, runner.guard(filepath.Join(root, "bin", "sh")), ErrProtected, "the sh link should be protected")
+		results, err := runner.Run(context.Background(), []string{filepath.Join(root, "bin", "sh")})
+		assert.ErrorIs(t, err, ErrProtected, "error should be ErrProtected")
+		assert.Contains(t, results[0].Cause(), "shell", "cause should contain the kind of file")
+		assert.NoFileExists(t, filepath.Join(outdir, "sh"), "nothing should be installed")
+	})
+	t.Run("Plan reports protected files as problems", func(t *testing.T) {
+		initPath := filepath.Join(t.TempDir(), "init")
+		assert.NoError(t, os.WriteFile(initPath, testELF("init"), 0755), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), SkipPreflight: true})
+
+		entries, err := runner.Plan(context.Background(), []string{initPath})
+		assert.NoError(t, err, "error should be nil")
+		assert.True(t, HasProblems(entries), "the plan should have problems")
+	})
+}
+
+func TestRunner_Warnings(t *testing.T) {
+	t.Run("warnings flags files being executed in place", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("running executables are read from /proc on linux")
+		}
+		executable, err := os.Executable()
+		assert.NoError(t, err, "error should be nil")
+		runner := newTestRunner(t, nil, Options{})
+		assert.Contains(t, runner.warnings(executable), "currently being executed, running processes keep the original", "the running executable should be flagged")
+	})
+	t.Run("Plan flags files being executed in place", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("running executables are read from /proc on linux")
+		}
+		sleep, err := exec.LookPath("sleep")
+		if err != nil {
+			t.Skip("sleep is not available")
+		}
+		content, err := os.ReadFile(sleep)
+		assert.NoError(t, err, "error should be nil")
+		running := filepath.Join(t.TempDir(), "sleep")
+		assert.NoError(t, os.WriteFile(running, content, 0755), "error should be nil")
+		cmd := exec.Command(running, "30")
+		assert.NoError(t, cmd.Start(), "error should be nil")
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+		runner := newTestRunner(t, nil, Options{SkipPreflight: true})
+
+		entries, err := runner.Plan(context.Background(), []string{running})
+		assert.NoError(t, err, "error should be nil")
+		assert.Contains(t, entries[0].Warnings, "currently being executed, running processes keep the original", "the running file should be flagged")
+	})
+	t.Run("warnings flags files owned by another user in place", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("changing the owner of a file requires root")
+		}
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		assert.NoError(t, os.Chown(testFile.Name(), 65534, 65534), "error should be nil")
+		runner := newTestRunner(t, nil, Options{})
+		assert.Equal(t, []string{"owned by another user (uid 65534)"}, runner.warnings(testFile.Name()), "the owner should be flagged")
+
+		runner = newTestRunner(t, nil, Options{OutputFolder: t.TempDir()})
+		assert.Empty(t, runner.warnings(testFile.Name()), "files that are not replaced should not be flagged")
+	})
+}
@@ -0,0 +1,85 @@
+package forge
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+)
+
+// gzipMagic are the first bytes of a gzip stream, executables never start with them
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// compressBody streams the gzip compression of body, the returned reader counts the compressed bytes
+func compressBody(body io.Reader) *countingReader {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		gzipWriter := gzip.NewWriter(pipeWriter)
+		_, err := io.Copy(gzipWriter, body)
+		if closeErr := gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return &countingReader{ReadCloser: pipeReader}
+}
+
+// gzipEncoding is a request editor marking the request body as gzip compressed
+func gzipEncoding(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+	return nil
+}
+
+// gzipResponseBody decompresses a gzip response while it is read and counts the bytes on both sides
+type gzipResponseBody struct {
+	gzipReader *gzip.Reader
+	compressed *countingReader
+	size       int64
+}
+
+func (g *gzipResponseBody) Read(p []byte) (int, error) {
+	n, err := g.gzipReader.Read(p)
+	g.size += int64(n)
+	return n, err
+}
+
+func (g *gzipResponseBody) Close() error {
+	g.gzipReader.Close()
+	return g.compressed.Close()
+}
+
+// decompressBody returns the body of a response, decompressed when it is gzip encoded
+// or when the beacon itself was sent as a gzip stream
+func decompressBody(responseBody io.ReadCloser, header http.Header) (io.ReadCloser, error) {
+	compressed := &countingReader{ReadCloser: responseBody}
+	buffered := bufio.NewReader(compressed)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	isGzip := header.Get("Content-Encoding") == "gzip" || string(magic) == string(gzipMagic)
+	body := struct {
+		io.Reader
+		io.Closer
+	}{buffered, compressed}
+	if !isGzip {
+		return body, nil
+	}
+	gzipReader, err := gzip.NewReader(buffered)
+	if err != nil {
+		responseBody.Close()
+		return nil, err
+	}
+	return &gzipResponseBody{gzipReader: gzipReader, compressed: compressed}, nil
+}
@@ -0,0 +1,50 @@
+package forge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRunner_Gzip(t *testing.T) {
+	var uploaded []byte
+	var contentEncoding, gzipParam string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncoding, gzipParam = r.Header.Get("Content-Encoding"), r.URL.Query().Get("gzip")
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		uploaded, _ = io.ReadAll(gzipReader)
+		w.Header().Set("Content-Encoding", "gzip")
+		gzipWriter := gzip.NewWriter(w)
+		gzipWriter.Write(testELF("beacon"))
+		gzipWriter.Close()
+	}))
+	defer testServer.Close()
+
+	t.Run("CreateBinary compresses the upload and decompresses the beacon", func(t *testing.T) {
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, SkipPreflight: true, BeaconOpts: BeaconOptions{Gzip: true}})
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		original, err := os.ReadFile(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+
+		binary, err := runner.CreateBinary(context.Background(), testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+		defer os.Remove(binary.tempFilePath.Name())
+		assert.Equal(t, "gzip", contentEncoding, "upload should be gzip encoded")
+		assert.Equal(t, "true", gzipParam, "gzip should be requested from the creator")
+		assert.True(t, bytes.Equal(original, uploaded), "the uploaded binary should decompress to the original")
+		beacon, err := os.ReadFile(binary.tempFilePath.Name())
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, testELF("beacon"), beacon, "the beacon should be decompressed")
+	})
+}
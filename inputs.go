@@ -0,0 +1,117 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoMatch is returned when a glob pattern does not match any file
+var ErrNoMatch = errors.New("pattern does not match any file")
+
+// InputOptions controls how directories and glob patterns are expanded into files
+type InputOptions struct {
+	// Recursive descends into the subdirectories of directories
+	Recursive bool
+	// MaxDepth limits how deep Recursive descends, a depth of 1 only includes the files of the directory itself.
+	// Zero means unlimited.
+	MaxDepth int
+	// Include keeps only files matching one of the patterns, all files are kept when empty
+	Include []string
+	// Exclude removes files matching one of the patterns
+	Exclude []string
+}
+
+// ExpandInputs resolves directories and shell-style glob patterns into the list of files to convert.
+// Patterns in Include and Exclude are matched against the file name, or against the whole path when they contain a separator.
+// The result keeps the order of paths and contains every file once.
+func ExpandInputs(paths []string, opts InputOptions) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		file = filepath.Clean(file)
+		if !seen[file] && opts.matches(file) {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, path := range paths {
+		matches := []string{path}
+		if isGlob(path) {
+			var err error
+			if matches, err = filepath.Glob(path); err != nil {
+				return nil, fmt.Errorf("error expanding %s: %w", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrNoMatch, path)
+			}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				// missing files are reported when they are converted
+				add(match)
+				continue
+			}
+			if err := opts.walk(match, 1, add); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// walk adds the files of dir, descending into subdirectories when Recursive is set
+func (o InputOptions) walk(dir string, depth int, add func(string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			// dangling symlinks are skipped
+			continue
+		}
+		if !info.IsDir() {
+			if info.Mode().IsRegular() {
+				add(path)
+			}
+			continue
+		}
+		if o.Recursive && (o.MaxDepth == 0 || depth < o.MaxDepth) && entry.Type()&os.ModeSymlink == 0 {
+			if err := o.walk(path, depth+1, add); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether file passes the include and exclude patterns
+func (o InputOptions) matches(file string) bool {
+	if len(o.Include) > 0 && !matchesAny(o.Include, file) {
+		return false
+	}
+	return !matchesAny(o.Exclude, file)
+}
+
+func matchesAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		name := filepath.Base(file)
+		if strings.ContainsRune(pattern, filepath.Separator) {
+			name = file
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
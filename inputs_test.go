@@ -0,0 +1,52 @@
+package forge
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandInputs(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"md5sum", "sha256sum", "script.sh", "sub/id", "sub/deeper/whoami"} {
+		path := filepath.Join(root, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(path, []byte(file), 0755), "error should be nil")
+	}
+	join := func(files ...string) []string {
+		for i, file := range files {
+			files[i] = filepath.Join(root, file)
+		}
+		return files
+	}
+
+	t.Run("ExpandInputs lists the files of a directory", func(t *testing.T) {
+		files, err := ExpandInputs([]string{root}, InputOptions{})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, join("md5sum", "script.sh", "sha256sum"), files, "only the files of the directory should be listed")
+	})
+	t.Run("ExpandInputs descends into subdirectories up to the depth limit", func(t *testing.T) {
+		files, err := ExpandInputs([]string{root}, InputOptions{Recursive: true, MaxDepth: 2})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, join("md5sum", "script.sh", "sha256sum", "sub/id"), files, "files up to depth 2 should be listed")
+
+		files, err = ExpandInputs([]string{root}, InputOptions{Recursive: true})
+		assert.NoError(t, err, "error should be nil")
+		assert.Contains(t, files, filepath.Join(root, "sub/deeper/whoami"), "all files should be listed without a depth limit")
+	})
+	t.Run("ExpandInputs expands glob patterns once per file", func(t *testing.T) {
+		files, err := ExpandInputs([]string{filepath.Join(root, "*sum"), filepath.Join(root, "md5sum")}, InputOptions{})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, join("md5sum", "sha256sum"), files, "every matching file should be listed once")
+	})
+	t.Run("ExpandInputs applies include and exclude patterns", func(t *testing.T) {
+		files, err := ExpandInputs([]string{root}, InputOptions{Recursive: true, Include: []string{"*sum", "id"}, Exclude: []string{"sha*"}})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, join("md5sum", "sub/id"), files, "only included files that are not excluded should be listed")
+	})
+	t.Run("ExpandInputs returns ErrNoMatch for patterns without matches", func(t *testing.T) {
+		_, err := ExpandInputs([]string{filepath.Join(root, "*.exe")}, InputOptions{})
+		assert.ErrorIs(t, err, ErrNoMatch, "error should be ErrNoMatch")
+	})
+}
@@ -0,0 +1,105 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// createTempNear creates a hidden temp file in the folder of destination so it can be renamed over it,
+// falling back to the system temp folder if the folder of destination is not writable
+func createTempNear(destination string) (*os.File, error) {
+	pattern := "." + filepath.Base(destination) + ".forge-*"
+	tempFile, err := os.CreateTemp(filepath.Dir(destination), pattern)
+	if err == nil {
+		return tempFile, nil
+	}
+	tempFile, fallbackErr := os.CreateTemp(os.TempDir(), pattern)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("error creating temp file next to %s: %w", destination, err)
+	}
+	return tempFile, nil
+}
+
+// installFile atomically replaces destination with the synced file at tempPath.
+// When they are on different filesystems the file is first copied next to destination and prepare,
+// if not nil, is called on the copy to set the attributes a copy does not carry over.
+// The folder of destination is synced so the rename survives a crash.
+func installFile(tempPath string, destination string, prepare func(path string) error) error {
+	err := os.Rename(tempPath, destination)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyRename(tempPath, destination, prepare)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(destination))
+}
+
+// copyRename copies source to a temp file next to destination, syncs it and renames it over destination.
+// The copy is removed on any failure and source is removed once installed.
+func copyRename(source string, destination string, prepare func(path string) error) (err error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("error opening temp file: %w", err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting temp file info: %w", err)
+	}
+	out, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".forge-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file next to destination: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("error copying temp file next to destination: %w", err)
+	}
+	if err := out.Chmod(info.Mode()); err != nil {
+		return fmt.Errorf("error setting permissions of the copy: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("error syncing the copy: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error closing the copy: %w", err)
+	}
+	if prepare != nil {
+		if err := prepare(out.Name()); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(out.Name(), destination); err != nil {
+		return err
+	}
+	os.Remove(source)
+	return nil
+}
+
+// syncDir flushes the entries of the folder to disk
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// folders cannot be synced on windows
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening folder: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		// some filesystems do not support syncing folders and return EINVAL
+		return fmt.Errorf("error syncing folder: %w", err)
+	}
+	return nil
+}
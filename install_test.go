@@ -0,0 +1,81 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assertNoTempFiles fails if a forge temp file is left in dir
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err, "error should be nil")
+	for _, entry := range entries {
+		assert.False(t, strings.Contains(entry.Name(), ".forge-"), "temp file %s should be removed", entry.Name())
+	}
+}
+
+func TestCopyRename(t *testing.T) {
+	t.Run("copyRename installs a copy with the same permissions and removes the source", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "beacon")
+		assert.NoError(t, os.WriteFile(source, []byte("beacon"), 0750), "error should be nil")
+		dir := t.TempDir()
+		destination := filepath.Join(dir, "id")
+		assert.NoError(t, os.WriteFile(destination, []byte("original"), 0755), "error should be nil")
+
+		assert.NoError(t, copyRename(source, destination, nil), "error should be nil")
+		content, err := os.ReadFile(destination)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, "beacon", string(content), "destination should be replaced")
+		info, err := os.Stat(destination)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), "permissions should be copied")
+		assert.NoFileExists(t, source, "source should be removed")
+		assertNoTempFiles(t, dir)
+	})
+
+	t.Run("copyRename keeps the source when the destination cannot be written", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "beacon")
+		assert.NoError(t, os.WriteFile(source, []byte("beacon"), 0750), "error should be nil")
+		destination := filepath.Join(t.TempDir(), "missing", "id")
+
+		assert.Error(t, copyRename(source, destination, nil), "error should not be nil")
+		assert.FileExists(t, source, "source should be kept")
+	})
+}
+
+func TestRunner_Install(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("\x7fELF beacon"))
+	defer testServer.Close()
+
+	t.Run("beacons are staged next to the destination and no temp file is left", func(t *testing.T) {
+		dir := t.TempDir()
+		id := filepath.Join(dir, "id")
+		testFile := createTestExecutable(t, "id")
+		assert.NoError(t, os.Rename(testFile.Name(), id), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL})
+
+		binary, err := runner.CreateBinary(context.Background(), id)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, dir, filepath.Dir(binary.tempFilePath.Name()), "temp file should be next to the destination")
+		assert.NoError(t, runner.OverwriteBinary(binary, &Result{}), "error should be nil")
+		assertNoTempFiles(t, dir)
+	})
+
+	t.Run("temp files are removed when the batch is aborted", func(t *testing.T) {
+		dir := t.TempDir()
+		id := filepath.Join(dir, "id")
+		testFile := createTestExecutable(t, "id")
+		assert.NoError(t, os.Rename(testFile.Name(), id), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL})
+
+		_, err := runner.Run(context.Background(), []string{id, filepath.Join(dir, "missing")})
+		assert.Error(t, err, "error should not be nil")
+		assertNoTempFiles(t, dir)
+	})
+}
@@ -0,0 +1,73 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Layouts of the output folder
+const (
+	// LayoutFlat writes every beacon directly in the output folder under the name of its file
+	LayoutFlat = "flat"
+	// LayoutTree mirrors the absolute path of every file under the output folder, like a root filesystem
+	LayoutTree = "tree"
+)
+
+var (
+	// ErrInvalidLayout is returned for a layout other than LayoutFlat or LayoutTree
+	ErrInvalidLayout = errors.New("invalid layout")
+	// ErrDestinationConflict is returned for a file whose destination is already taken by another file of the run
+	ErrDestinationConflict = errors.New("destination conflict")
+)
+
+// validateLayout returns ErrInvalidLayout for an unknown layout, empty means LayoutFlat
+func validateLayout(layout string) error {
+	switch layout {
+	case "", LayoutFlat, LayoutTree:
+		return nil
+	}
+	return fmt.Errorf("%w %q, must be %s or %s", ErrInvalidLayout, layout, LayoutFlat, LayoutTree)
+}
+
+// treeDestination returns the path of the file mirrored under folder
+func treeDestination(folder string, originalFilePath string) string {
+	absolute, err := filepath.Abs(originalFilePath)
+	if err != nil {
+		absolute = originalFilePath
+	}
+	return filepath.Join(folder, strings.TrimPrefix(absolute, filepath.VolumeName(absolute)))
+}
+
+// claimDestinations gives every destination to the first file or link claiming it,
+// the other files and links fail with ErrDestinationConflict instead of overwriting it
+func (r *Runner) claimDestinations(paths []string, links []link) ([]string, []link, []*Result) {
+	claimed := map[string]string{}
+	var conflicts []*Result
+	claim := func(filePath string) bool {
+		destination := r.destinationFor(filePath)
+		first, ok := claimed[destination]
+		if !ok {
+			claimed[destination] = filePath
+			return true
+		}
+		err := fmt.Errorf("%w: %s is also the destination of %s", ErrDestinationConflict, destination, first)
+		conflicts = append(conflicts, &Result{Path: filePath, Err: &FileError{Path: filePath, Op: OpDestination, Err: err}})
+		return false
+	}
+	var claimedPaths []string
+	for _, filePath := range paths {
+		if claim(filePath) {
+			claimedPaths = append(claimedPaths, filePath)
+		}
+	}
+	var claimedLinks []link
+	for _, link := range links {
+		// a link sharing the destination of its target is installed with the target
+		if r.destinationFor(link.path) == r.destinationFor(link.target) || claim(link.path) {
+			claimedLinks = append(claimedLinks, link)
+		}
+	}
+	return claimedPaths, claimedLinks, conflicts
+}
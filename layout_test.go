@@ -0,0 +1,72 @@
+package forge
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestFinds creates two different executables named find in different folders
+func createTestFinds(t *testing.T) (first string, second string) {
+	t.Helper()
+	first, second = filepath.Join(t.TempDir(), "find"), filepath.Join(t.TempDir(), "find")
+	for i, path := range []string{first, second} {
+		testFile := createTestExecutable(t, string(rune('a'+i)))
+		assert.NoError(t, os.Rename(testFile.Name(), path), "error should be nil")
+	}
+	return first, second
+}
+
+func TestRunner_Layout(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("\x7fELF beacon"))
+	defer testServer.Close()
+
+	t.Run("NewRunner rejects an unknown layout", func(t *testing.T) {
+		isolateEnv(t)
+		_, err := NewRunner(nil, &Options{CreatorUrl: testServer.URL, Layout: "nested"})
+		assert.ErrorIs(t, err, ErrInvalidLayout, "error should be ErrInvalidLayout")
+	})
+
+	t.Run("tree layout mirrors the source paths under the output folder", func(t *testing.T) {
+		first, second := createTestFinds(t)
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, Layout: LayoutTree})
+
+		results, err := runner.Run(context.Background(), []string{first, second})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, filepath.Join(outdir, first), results[0].Destination, "first should be mirrored")
+		assert.Equal(t, filepath.Join(outdir, second), results[1].Destination, "second should be mirrored")
+		assert.FileExists(t, filepath.Join(outdir, first), "first should be installed")
+		assert.FileExists(t, filepath.Join(outdir, second), "second should be installed")
+	})
+
+	t.Run("flat layout fails on colliding names instead of overwriting", func(t *testing.T) {
+		first, second := createTestFinds(t)
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir})
+
+		results, err := runner.Run(context.Background(), []string{first, second})
+		assert.ErrorIs(t, err, ErrDestinationConflict, "error should be ErrDestinationConflict")
+		assert.ErrorIs(t, results[0].Err, ErrAborted, "first should be aborted")
+		var fileErr *FileError
+		assert.True(t, errors.As(results[1].Err, &fileErr), "error should be a FileError")
+		assert.Equal(t, OpDestination, fileErr.Op, "destination should be the failed operation")
+		assert.Contains(t, fileErr.Error(), first, "error should name the file holding the destination")
+		assert.NoFileExists(t, filepath.Join(outdir, "find"), "nothing should be installed")
+	})
+
+	t.Run("flat layout installs the first file with continue on error", func(t *testing.T) {
+		first, second := createTestFinds(t)
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, ContinueOnError: true})
+
+		results, err := runner.Run(context.Background(), []string{first, second})
+		assert.Error(t, err, "error should not be nil")
+		assert.Equal(t, StatusConverted, results[0].Status(), "first should be converted")
+		assert.ErrorIs(t, results[1].Err, ErrDestinationConflict, "second should conflict")
+	})
+}
@@ -0,0 +1,207 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Policies for inputs that are symbolic or hard links
+const (
+	// LinkTarget converts the file the links point to once and keeps the links,
+	// so multi-call binaries like busybox still dispatch on argv[0]
+	LinkTarget = "target"
+	// LinkReplace replaces every link with a standalone beacon
+	LinkReplace = "replace"
+	// LinkSkip skips symbolic links and files with several hard links
+	LinkSkip = "skip"
+)
+
+// ErrInvalidLinkPolicy is returned for a link policy other than LinkTarget, LinkReplace or LinkSkip
+var ErrInvalidLinkPolicy = errors.New("invalid link policy")
+
+// fileID identifies a file independently of the path used to reach it
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// link is an input that shares the beacon of the file it points to instead of being converted itself
+type link struct {
+	path   string
+	target string
+	hard   bool
+}
+
+// validateLinkPolicy returns ErrInvalidLinkPolicy for an unknown policy, empty means LinkTarget
+func validateLinkPolicy(policy string) error {
+	switch policy {
+	case "", LinkTarget, LinkReplace, LinkSkip:
+		return nil
+	}
+	return fmt.Errorf("%w %q, must be one of %s, %s or %s", ErrInvalidLinkPolicy, policy, LinkTarget, LinkReplace, LinkSkip)
+}
+
+// resolveLinks splits the inputs into the files to convert, the links to install once their target is converted
+// and the results of the inputs that are not converted: links skipped by the policy and links leaving the root
+func (r *Runner) resolveLinks(filePaths []string) (paths []string, links []link, excluded []*Result) {
+	seen := map[string]bool{}
+	files := map[fileID]string{}
+	// add queues a file for conversion, returning the path of the file holding its beacon
+	add := func(filePath string) (string, bool) {
+		info, err := os.Stat(filePath)
+		if err == nil {
+			if id, _, ok := fileIdentity(info); ok {
+				if first, ok := files[id]; ok {
+					return first, first != filePath
+				}
+				files[id] = filePath
+			}
+		}
+		if !seen[filePath] {
+			seen[filePath] = true
+			paths = append(paths, filePath)
+		}
+		return filePath, false
+	}
+	// addSymlink converts the target of a symbolic link and queues the link, unless the policy skips it
+	addSymlink := func(filePath string, target string) {
+		if r.opts.LinkPolicy == LinkSkip {
+			excluded = append(excluded, &Result{Path: filePath, SkipReason: SkipSymlink})
+			return
+		}
+		target, _ = add(target)
+		links = append(links, link{path: filePath, target: target})
+	}
+	for _, filePath := range filePaths {
+		if r.opts.Root != "" && withinRoot(r.opts.Root, filePath) {
+			// the links of a root filesystem resolve differently on the host, they are only followed inside the root
+			target, err := r.resolveInRoot(filePath)
+			if err != nil {
+				excluded = append(excluded, &Result{Path: filePath, Err: &FileError{Path: filePath, Op: OpLink, Err: err}})
+				continue
+			}
+			if target != filepath.Clean(filePath) && r.opts.LinkPolicy != LinkReplace {
+				addSymlink(filePath, target)
+				continue
+			}
+		}
+		if r.opts.LinkPolicy == LinkReplace {
+			if !seen[filePath] {
+				seen[filePath] = true
+				paths = append(paths, filePath)
+			}
+			continue
+		}
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			// reported when the file is converted
+			add(filePath)
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(filePath)
+			if err != nil {
+				add(filePath)
+				continue
+			}
+			addSymlink(filePath, target)
+			continue
+		}
+		if _, nlink, ok := fileIdentity(info); ok && nlink > 1 && r.opts.LinkPolicy == LinkSkip {
+			excluded = append(excluded, &Result{Path: filePath, SkipReason: SkipHardLink})
+			continue
+		}
+		if target, shared := add(filePath); shared {
+			links = append(links, link{path: filePath, target: target, hard: true})
+		}
+	}
+	return paths, links, excluded
+}
+
+// resolveInRoot resolves the links of filePath inside the root filesystem. With LinkReplace the file is read
+// through its links on the host, so links reaching another file on the host than inside the root are rejected.
+func (r *Runner) resolveInRoot(filePath string) (string, error) {
+	target, err := evalSymlinksInRoot(r.opts.Root, filePath)
+	if err != nil || r.opts.LinkPolicy != LinkReplace || target == filepath.Clean(filePath) {
+		return target, err
+	}
+	inRoot, err := os.Stat(target)
+	if err != nil {
+		return "", err
+	}
+	onHost, err := os.Stat(filePath)
+	if err != nil || !os.SameFile(inRoot, onHost) {
+		return "", fmt.Errorf("%w on the host, use the %s link policy", ErrOutsideRoot, LinkTarget)
+	}
+	return target, nil
+}
+
+// linkResults returns the results of the links, installing the links whose target was converted
+func (r *Runner) linkResults(links []link, converted map[string]*Result) []*Result {
+	results := make([]*Result, len(links))
+	for i, link := range links {
+		target, ok := converted[link.target]
+		if !ok {
+			results[i] = &Result{Path: link.path, Err: &FileError{Path: link.path, Op: OpLink, Err: fmt.Errorf("target %s was not converted", link.target)}}
+			continue
+		}
+		result := &Result{Path: link.path, Target: target.Target, Params: target.Params, Source: target.Source, Beacon: target.Beacon}
+		switch target.Status() {
+		case StatusConverted:
+			result.Err = r.installLink(link, target, result)
+		case StatusSkipped:
+			result.SkipReason = target.SkipReason
+		case StatusAborted:
+			result.Err = ErrAborted
+		default:
+			result.Err = &FileError{Path: link.path, Op: OpLink, Err: fmt.Errorf("target %s was not converted", link.target)}
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// installLink points the destination of the link to the beacon of its target
+func (r *Runner) installLink(link link, target *Result, result *Result) error {
+	destination, err := r.getDestinationFilePath(link.path)
+	if err != nil {
+		return err
+	}
+	if (destination == link.path && !link.hard) || destination == target.Destination {
+		// the link already reaches the beacon
+		result.Destination = destination
+		return nil
+	}
+	tempPath := filepath.Join(filepath.Dir(destination), "."+filepath.Base(destination)+".forge-link")
+	os.Remove(tempPath)
+	if link.hard {
+		err = os.Link(target.Destination, tempPath)
+	} else {
+		var relative string
+		if relative, err = filepath.Rel(filepath.Dir(destination), target.Destination); err == nil {
+			err = os.Symlink(relative, tempPath)
+		}
+	}
+	if err != nil {
+		return &FileError{Path: link.path, Op: OpLink, Err: err}
+	}
+	if result.Backup, err = r.backupOriginal(link.path, destination, target.Beacon.SHA256); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	r.logger.With(zap.String("link", destination), zap.String("target", target.Destination), zap.Bool("hard", link.hard)).Info("Installing link")
+	if err := os.Rename(tempPath, destination); err != nil {
+		os.Remove(tempPath)
+		return &FileError{Path: link.path, Op: OpRename, Err: fmt.Errorf("error renaming link to destination: %w", err)}
+	}
+	if err := syncDir(filepath.Dir(destination)); err != nil {
+		return &FileError{Path: link.path, Op: OpRename, Err: err}
+	}
+	result.Destination = destination
+	result.InstalledAt = time.Now().UTC()
+	return nil
+}
@@ -0,0 +1,178 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestBusybox creates an executable named busybox in a temporary folder
+func createTestBusybox(t *testing.T) (dir string, busybox string) {
+	t.Helper()
+	dir = t.TempDir()
+	busybox = filepath.Join(dir, "busybox")
+	testFile := createTestExecutable(t, "busybox")
+	assert.NoError(t, os.Rename(testFile.Name(), busybox), "error should be nil")
+	return dir, busybox
+}
+
+func TestRunner_Links(t *testing.T) {
+	const beacon = "\x7fELF beacon"
+	testServer := httptest.NewServer(testCreatorHandler(beacon))
+	defer testServer.Close()
+
+	t.Run("NewRunner rejects an unknown link policy", func(t *testing.T) {
+		isolateEnv(t)
+		_, err := NewRunner(nil, &Options{CreatorUrl: testServer.URL, LinkPolicy: "follow"})
+		assert.ErrorIs(t, err, ErrInvalidLinkPolicy, "error should be ErrInvalidLinkPolicy")
+	})
+
+	t.Run("target policy converts the target once and keeps symlinks in place", func(t *testing.T) {
+		dir, busybox := createTestBusybox(t)
+		sh := filepath.Join(dir, "sh")
+		assert.NoError(t, os.Symlink("busybox", sh), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL})
+
+		results, err := runner.Run(context.Background(), []string{sh})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, results, 2, "the target should be converted along with the link")
+		assert.Equal(t, sh, results[0].Path, "the link should come first")
+		assert.Equal(t, busybox, results[1].Path, "the target should be appended")
+		assert.Equal(t, StatusConverted, results[0].Status(), "the link should be converted")
+		linkTarget, err := os.Readlink(sh)
+		assert.NoError(t, err, "sh should still be a symlink")
+		assert.Equal(t, "busybox", linkTarget, "sh should still point to busybox")
+		content, err := os.ReadFile(busybox)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, string(testELF(beacon)), string(content), "busybox should be a beacon")
+	})
+
+	t.Run("target policy recreates symlinks in the output folder", func(t *testing.T) {
+		dir, busybox := createTestBusybox(t)
+		sh := filepath.Join(dir, "sh")
+		assert.NoError(t, os.Symlink(busybox, sh), "error should be nil")
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir})
+
+		results, err := runner.Run(context.Background(), []string{busybox, sh})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, results, 2, "there should be a result per input")
+		linkTarget, err := os.Readlink(filepath.Join(outdir, "sh"))
+		assert.NoError(t, err, "sh should be a symlink")
+		assert.Equal(t, "busybox", linkTarget, "sh should point to the beacon")
+	})
+
+	t.Run("target policy converts hard links once and links the beacon", func(t *testing.T) {
+		dir, busybox := createTestBusybox(t)
+		echo := filepath.Join(dir, "echo")
+		assert.NoError(t, os.Link(busybox, echo), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL})
+
+		results, err := runner.Run(context.Background(), []string{busybox, echo})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, StatusConverted, results[1].Status(), "the hard link should be converted")
+		busyboxInfo, err := os.Stat(busybox)
+		assert.NoError(t, err, "error should be nil")
+		echoInfo, err := os.Stat(echo)
+		assert.NoError(t, err, "error should be nil")
+		assert.True(t, os.SameFile(busyboxInfo, echoInfo), "echo should be a hard link to the beacon")
+		content, err := os.ReadFile(echo)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, string(testELF(beacon)), string(content), "echo should be a beacon")
+	})
+
+	t.Run("replace policy replaces the symlink with a standalone beacon", func(t *testing.T) {
+		dir, busybox := createTestBusybox(t)
+		sh := filepath.Join(dir, "sh")
+		assert.NoError(t, os.Symlink("busybox", sh), "error should be nil")
+		backupDir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, LinkPolicy: LinkReplace, BackupDir: backupDir})
+
+		results, err := runner.Run(context.Background(), []string{sh})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, results, 1, "only the link should be converted")
+		info, err := os.Lstat(sh)
+		assert.NoError(t, err, "error should be nil")
+		assert.True(t, info.Mode().IsRegular(), "sh should be a regular file")
+		content, err := os.ReadFile(busybox)
+		assert.NoError(t, err, "error should be nil")
+		assert.NotEqual(t, string(testELF(beacon)), string(content), "busybox should be untouched")
+
+		store, err := OpenBackupStore(backupDir)
+		assert.NoError(t, err, "error should be nil")
+		assert.NoError(t, store.Restore(sh), "error should be nil")
+		linkTarget, err := os.Readlink(sh)
+		assert.NoError(t, err, "sh should be a symlink again")
+		assert.Equal(t, "busybox", linkTarget, "sh should point to busybox again")
+	})
+
+	t.Run("skip policy skips symlinks and hard links", func(t *testing.T) {
+		dir, busybox := createTestBusybox(t)
+		sh := filepath.Join(dir, "sh")
+		assert.NoError(t, os.Symlink("busybox", sh), "error should be nil")
+		echo := filepath.Join(dir, "echo")
+		assert.NoError(t, os.Link(busybox, echo), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, LinkPolicy: LinkSkip})
+
+		results, err := runner.Run(context.Background(), []string{sh, echo})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, SkipSymlink, results[0].SkipReason, "sh should be skipped")
+		assert.Equal(t, SkipHardLink, results[1].SkipReason, "echo should be skipped")
+	})
+}
+
+func TestRunner_LinksInRoot(t *testing.T) {
+	const beacon = "\x7fELF beacon"
+	testServer := httptest.NewServer(testCreatorHandler(beacon))
+	defer testServer.Close()
+
+	// createTestRoot creates a root filesystem with bin/busybox and an absolute link bin/ls to /bin/busybox
+	createTestRoot := func(t *testing.T) (root string, ls string) {
+		root = t.TempDir()
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, "bin"), 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "bin", "busybox"), testELF("busybox"), 0755), "error should be nil")
+		ls = filepath.Join(root, "bin", "ls")
+		assert.NoError(t, os.Symlink("/bin/busybox", ls), "error should be nil")
+		return root, ls
+	}
+
+	t.Run("absolute links are resolved inside the root", func(t *testing.T) {
+		root, ls := createTestRoot(t)
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Root: root})
+
+		results, err := runner.Run(context.Background(), []string{ls})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, results, 2, "the target inside the root should be converted along with the link")
+		assert.Equal(t, filepath.Join(root, "bin", "busybox"), results[1].Path, "the target should be the busybox of the root")
+	})
+
+	t.Run("links leaving the root are rejected", func(t *testing.T) {
+		root := t.TempDir()
+		outside := createTestExecutable(t, "outside")
+		defer os.Remove(outside.Name())
+		relative, err := filepath.Rel(root, outside.Name())
+		assert.NoError(t, err, "error should be nil")
+		escape := filepath.Join(root, "escape")
+		assert.NoError(t, os.Symlink(relative, escape), "error should be nil")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, Root: root})
+
+		results, err := runner.Run(context.Background(), []string{escape})
+		assert.ErrorIs(t, err, ErrOutsideRoot, "error should be ErrOutsideRoot")
+		assert.Len(t, results, 1, "the target outside the root should not be converted")
+		content, err := os.ReadFile(outside.Name())
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, string(testELF("outside")), string(content), "the file outside the root should be untouched")
+	})
+
+	t.Run("replace policy rejects links resolving to another file on the host", func(t *testing.T) {
+		root, ls := createTestRoot(t)
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Root: root, LinkPolicy: LinkReplace})
+
+		results, err := runner.Run(context.Background(), []string{ls})
+		assert.ErrorIs(t, err, ErrOutsideRoot, "error should be ErrOutsideRoot")
+		assert.Equal(t, StatusFailed, results[0].Status(), "the link should not be converted")
+	})
+}
@@ -0,0 +1,143 @@
+package forge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"io"
+	"os"
+	"time"
+)
+
+// Version is the version of forge, set at build time with -ldflags "-X github.com/SekyrOrg/forge.Version=..."
+var Version = "dev"
+
+// Digest identifies the content of a file
+type Digest struct {
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// digestFile returns the digest of the file at path
+func digestFile(path string) (Digest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Digest{}, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return Digest{}, fmt.Errorf("error hashing file: %w", err)
+	}
+	return Digest{SHA256: hex.EncodeToString(hasher.Sum(nil)), Size: size}, nil
+}
+
+// Manifest is the machine-readable record of a forge run
+type Manifest struct {
+	ForgeVersion string          `json:"forge_version"`
+	CreatedAt    time.Time       `json:"created_at"`
+	CreatorUrl   string          `json:"creator_url"`
+	Mode         string          `json:"mode"`
+	OutputFolder string          `json:"output_folder,omitempty"`
+	Entries      []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry records the conversion of a single file
+type ManifestEntry struct {
+	Path        string                     `json:"path"`
+	Status      string                     `json:"status"`
+	Error       string                     `json:"error,omitempty"`
+	SkipReason  string                     `json:"skip_reason,omitempty"`
+	Unpreserved []string                   `json:"unpreserved,omitempty"`
+	Warnings    []string                   `json:"warnings,omitempty"`
+	Destination string                     `json:"destination,omitempty"`
+	Backup      string                     `json:"backup,omitempty"`
+	Target      Target                     `json:"target"`
+	Params      *openapi.PostCreatorParams `json:"params,omitempty"`
+	Before      Digest                     `json:"before"`
+	After       Digest                     `json:"after"`
+	InstalledAt *time.Time                 `json:"installed_at,omitempty"`
+}
+
+// NewManifest creates the manifest of a run from its results
+func NewManifest(opts *Options, results []*Result) *Manifest {
+	manifest := &Manifest{
+		ForgeVersion: Version,
+		CreatedAt:    time.Now().UTC(),
+		CreatorUrl:   opts.CreatorUrl,
+		Mode:         opts.Mode(),
+		OutputFolder: opts.OutputFolder,
+		Entries:      make([]ManifestEntry, 0, len(results)),
+	}
+	for _, result := range results {
+		entry := ManifestEntry{
+			Path:        result.Path,
+			Status:      result.Status(),
+			SkipReason:  result.SkipReason,
+			Unpreserved: result.Unpreserved,
+			Warnings:    result.Warnings,
+			Destination: result.Destination,
+			Backup:      result.Backup,
+			Target:      result.Target,
+			Params:      result.Params,
+			Before:      result.Source,
+			After:       result.Beacon,
+		}
+		if result.Err != nil {
+			entry.Error = result.Cause()
+		}
+		if !result.InstalledAt.IsZero() {
+			installedAt := result.InstalledAt
+			entry.InstalledAt = &installedAt
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	return manifest
+}
+
+// WriteManifest writes the manifest as JSON to path
+func WriteManifest(path string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest reads a manifest written by a previous run
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// writeManifest writes the manifest of the run if a manifest path is configured. The beacons of previous runs
+// whose files were not processed again are carried forward, so later runs still skip them.
+func (r *Runner) writeManifest(results []*Result) error {
+	if r.opts.ManifestPath == "" {
+		return nil
+	}
+	manifest := NewManifest(r.opts, results)
+	processed := map[string]bool{}
+	for _, entry := range manifest.Entries {
+		processed[entry.Path] = true
+	}
+	for _, entry := range r.earlier {
+		if !processed[entry.Path] {
+			manifest.Entries = append(manifest.Entries, entry)
+		}
+	}
+	return WriteManifest(r.opts.ManifestPath, manifest)
+}
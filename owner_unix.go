@@ -0,0 +1,26 @@
+//go:build !windows
+
+package forge
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of the file, ok is false if they are not available
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// fileIdentity returns the device and inode of the file and its number of hard links, ok is false if they are not available
+func fileIdentity(info os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, uint64(stat.Nlink), true
+}
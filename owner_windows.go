@@ -0,0 +1,13 @@
+package forge
+
+import "os"
+
+// fileOwner returns the uid and gid of the file, ok is false if they are not available
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
+
+// fileIdentity returns the device and inode of the file and its number of hard links, ok is false if they are not available
+func fileIdentity(info os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	return fileID{}, 0, false
+}
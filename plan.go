@@ -0,0 +1,192 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SekyrOrg/forge/openapi"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// Actions of a PlanEntry
+const (
+	ActionConvert = "convert"
+	ActionLink    = "link"
+	ActionSkip    = "skip"
+)
+
+// PlanEntry is what a run would do with a single file
+type PlanEntry struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// Reason is why the file is skipped
+	Reason string                     `json:"reason,omitempty"`
+	Target Target                     `json:"target"`
+	Params *openapi.PostCreatorParams `json:"params,omitempty"`
+	// LinkTarget is the converted file a link points to
+	LinkTarget  string `json:"link_target,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Warnings are the reasons to be careful with replacing the file, they do not make it fail
+	Warnings []string `json:"warnings,omitempty"`
+	// Problems are the reasons the file would fail, e.g. an unsupported target or a destination conflict
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Plan computes what Run would do with the files without uploading or changing any file.
+// The distlist of the creator is fetched to check the targets unless preflight is skipped.
+func (r *Runner) Plan(ctx context.Context, filePaths []string) ([]*PlanEntry, error) {
+	paths, links, excluded := r.resolveLinks(filePaths)
+	isLink := map[string]bool{}
+	for _, link := range links {
+		isLink[link.path] = true
+	}
+	paths, links, conflicts := r.claimDestinations(paths, links)
+	var entries []*PlanEntry
+	for _, filePath := range paths {
+		entries = append(entries, r.planFile(ctx, filePath))
+	}
+	converted := map[string]*PlanEntry{}
+	for _, entry := range entries {
+		converted[filepath.Clean(entry.Path)] = entry
+	}
+	for _, link := range links {
+		entry := &PlanEntry{Path: link.path, Action: ActionLink, LinkTarget: link.target}
+		target, ok := converted[filepath.Clean(link.target)]
+		switch {
+		case !ok:
+			entry.Problems = append(entry.Problems, fmt.Sprintf("target %s would not be converted", link.target))
+		case target.Action == ActionSkip:
+			entry.Action, entry.Reason = ActionSkip, target.Reason
+		default:
+			entry.Target, entry.Params = target.Target, target.Params
+			entry.Destination = r.destinationFor(link.path)
+		}
+		entries = append(entries, entry)
+	}
+	// like Run, the first file claiming a destination keeps it and the later ones fail
+	for _, result := range conflicts {
+		entry := &PlanEntry{Path: result.Path, Action: ActionConvert, Destination: r.destinationFor(result.Path), Problems: []string{result.Cause()}}
+		if isLink[result.Path] {
+			entry.Action = ActionLink
+		}
+		entries = append(entries, entry)
+	}
+	for _, result := range excluded {
+		entry := &PlanEntry{Path: result.Path, Action: ActionSkip, Reason: result.SkipReason}
+		if result.Err != nil {
+			entry.Action, entry.Problems = ActionLink, []string{result.Err.Error()}
+		}
+		entries = append(entries, entry)
+	}
+	return planOrder(filePaths, entries), nil
+}
+
+// planFile plans the conversion of a single file
+func (r *Runner) planFile(ctx context.Context, filePath string) *PlanEntry {
+	entry := &PlanEntry{Path: filePath, Action: ActionConvert}
+	filePath = filepath.Clean(filePath)
+	source, err := digestFile(filePath)
+	if err != nil {
+		entry.Problems = append(entry.Problems, (&FileError{Path: filePath, Op: OpOpen, Err: err}).Error())
+		return entry
+	}
+	if err := r.guard(filePath); err != nil {
+		entry.Problems = append(entry.Problems, err.Error())
+		return entry
+	}
+	reason, err := r.skipReason(filePath, source)
+	if err != nil {
+		entry.Problems = append(entry.Problems, err.Error())
+		return entry
+	}
+	if reason != "" {
+		entry.Action, entry.Reason = ActionSkip, reason
+		return entry
+	}
+	target, err := r.targetFor(filePath)
+	if err != nil {
+		entry.Problems = append(entry.Problems, err.Error())
+		return entry
+	}
+	entry.Target = target
+	entry.Params = r.paramsFor(target)
+	entry.Destination = r.destinationFor(filePath)
+	entry.Warnings = r.warnings(filePath)
+	if err := r.checkTarget(ctx, filePath, target); err != nil {
+		var fileErr *FileError
+		if errors.As(err, &fileErr) {
+			err = fileErr.Err
+		}
+		entry.Problems = append(entry.Problems, err.Error())
+	}
+	return entry
+}
+
+// planOrder returns the entries in the order of the inputs, followed by the link targets that were not inputs
+func planOrder(filePaths []string, entries []*PlanEntry) []*PlanEntry {
+	indexed := map[string]*PlanEntry{}
+	for _, entry := range entries {
+		indexed[entry.Path] = entry
+	}
+	ordered := make([]*PlanEntry, 0, len(entries))
+	for _, filePath := range filePaths {
+		if entry, ok := indexed[filePath]; ok {
+			ordered = append(ordered, entry)
+			delete(indexed, filePath)
+		}
+	}
+	for _, entry := range entries {
+		if _, ok := indexed[entry.Path]; ok {
+			ordered = append(ordered, entry)
+			delete(indexed, entry.Path)
+		}
+	}
+	return ordered
+}
+
+// HasProblems reports whether any entry of the plan would fail
+func HasProblems(entries []*PlanEntry) bool {
+	for _, entry := range entries {
+		if len(entry.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePlan writes the plan as a table, or as JSON if asJSON is set
+func WritePlan(w io.Writer, entries []*PlanEntry, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "FILE\tACTION\tTARGET\tDESTINATION\tNOTES")
+	for _, entry := range entries {
+		target, notes := "", entry.Reason
+		if entry.Params != nil {
+			target = entry.Target.String()
+		}
+		if entry.LinkTarget != "" {
+			notes = "to " + entry.LinkTarget
+		}
+		for _, warning := range entry.Warnings {
+			if notes != "" {
+				notes += "; "
+			}
+			notes += "warning: " + warning
+		}
+		for _, problem := range entry.Problems {
+			if notes != "" {
+				notes += "; "
+			}
+			notes += problem
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", entry.Path, entry.Action, target, entry.Destination, notes)
+	}
+	return table.Flush()
+}
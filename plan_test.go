@@ -0,0 +1,91 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForge_Plan(t *testing.T) {
+	var uploads atomic.Int32
+	handler := testCreatorHandler("\x7fELF beacon")
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/creator" {
+			uploads.Add(1)
+		}
+		handler(w, r)
+	}))
+	defer testServer.Close()
+
+	t.Run("Plan computes targets and destinations without uploading or creating files", func(t *testing.T) {
+		outdir := filepath.Join(t.TempDir(), "out")
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		script := filepath.Join(t.TempDir(), "script")
+		assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"), 0755), "error should be nil")
+
+		plan, err := f.Plan(context.Background(), []string{testFile.Name(), script})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, plan, 2, "there should be an entry per file")
+		assert.Equal(t, ActionConvert, plan[0].Action, "the executable should be converted")
+		assert.Equal(t, Target{Os: "linux", Arch: "amd64"}, plan[0].Target, "the target should be detected")
+		assert.Equal(t, "amd64", plan[0].Params.Arch, "the params should contain the target")
+		assert.Equal(t, filepath.Join(outdir, filepath.Base(testFile.Name())), plan[0].Destination, "the destination should be in the output folder")
+		assert.Empty(t, plan[0].Problems, "the executable should have no problems")
+		assert.Equal(t, ActionSkip, plan[1].Action, "the script should be skipped")
+		assert.Equal(t, SkipScript, plan[1].Reason, "the reason should be set")
+		assert.False(t, HasProblems(plan), "the plan should have no problems")
+		assert.Equal(t, int32(0), uploads.Load(), "nothing should be uploaded")
+		assert.NoDirExists(t, outdir, "the output folder should not be created")
+	})
+
+	t.Run("Plan flags unsupported targets and destination conflicts", func(t *testing.T) {
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), LinkPolicy: LinkReplace})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		content, err := os.ReadFile(testFile.Name())
+		assert.NoError(t, err, "error should be nil")
+		first, second := filepath.Join(t.TempDir(), "find"), filepath.Join(t.TempDir(), "find")
+		assert.NoError(t, os.WriteFile(first, content, 0755), "error should be nil")
+		assert.NoError(t, os.WriteFile(second, content, 0755), "error should be nil")
+
+		plan, err := f.Plan(context.Background(), []string{first, second, filepath.Join(t.TempDir(), "missing"), "testFiles/ls_darwin"})
+		assert.NoError(t, err, "error should be nil")
+		assert.Empty(t, plan[0].Problems, "the first file should keep its destination like in Run")
+		assert.Contains(t, plan[1].Problems[0], "is also the destination of "+first, "the second file should conflict with the first")
+		assert.Contains(t, plan[2].Problems[0], OpOpen, "the missing file should fail to open")
+		assert.Contains(t, plan[3].Problems[0], ErrUnsupportedTarget.Error(), "the darwin binary should be unsupported")
+		assert.True(t, HasProblems(plan), "the plan should have problems")
+
+		var buffer bytes.Buffer
+		assert.NoError(t, WritePlan(&buffer, plan, true), "error should be nil")
+		var decoded []*PlanEntry
+		assert.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded), "the plan should be valid JSON")
+		assert.Len(t, decoded, len(plan), "every entry should be written")
+	})
+
+	t.Run("Plan matches links to targets given with an unclean path", func(t *testing.T) {
+		dir, _ := createTestBusybox(t)
+		sh := filepath.Join(dir, "sh")
+		assert.NoError(t, os.Symlink("busybox", sh), "error should be nil")
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir()})
+		assert.NoError(t, err, "error should be nil")
+
+		plan, err := f.Plan(context.Background(), []string{dir + "//busybox", sh})
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, plan, 2, "the link and its target should be planned")
+		assert.Equal(t, ActionLink, plan[1].Action, "sh should be linked")
+		assert.Equal(t, dir+"//busybox", plan[1].LinkTarget, "sh should be linked to busybox")
+		assert.Empty(t, plan[1].Problems, "the link should have no problems")
+	})
+}
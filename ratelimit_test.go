@@ -0,0 +1,59 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("rateLimiter spaces out requests", func(t *testing.T) {
+		limiter := newRateLimiter(100)
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, limiter.Wait(context.Background()), "error should be nil")
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "5 requests at 100/s should take at least 40ms")
+	})
+	t.Run("nil rateLimiter never blocks", func(t *testing.T) {
+		var limiter *rateLimiter
+		assert.NoError(t, limiter.Wait(context.Background()), "error should be nil")
+	})
+}
+
+func TestRunner_RunConcurrency(t *testing.T) {
+	var current, highest int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		running := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			seen := atomic.LoadInt32(&highest)
+			if running <= seen || atomic.CompareAndSwapInt32(&highest, seen, running) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write(testELF("test"))
+	}))
+	defer testServer.Close()
+
+	t.Run("Run uploads at most Concurrency files at the same time", func(t *testing.T) {
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), Concurrency: 2, SkipPreflight: true})
+		var files []string
+		for i := 0; i < 6; i++ {
+			// distinct contents so the uploads are not deduplicated
+			testFile := createTestExecutable(t, strconv.Itoa(i))
+			defer os.Remove(testFile.Name())
+			files = append(files, testFile.Name())
+		}
+		_, err := runner.Run(context.Background(), files)
+		assert.NoError(t, err, "error should be nil")
+		assert.LessOrEqual(t, atomic.LoadInt32(&highest), int32(2), "no more than 2 uploads should run at the same time")
+	})
+}
@@ -0,0 +1,75 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunner_sendBinaryRetries(t *testing.T) {
+	newFailingServer := func(failures int32, status int, bodies *[]string) (*httptest.Server, *int32) {
+		var attempts int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			*bodies = append(*bodies, string(body))
+			if atomic.AddInt32(&attempts, 1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte("beacon"))
+		})), &attempts
+	}
+
+	t.Run("sendBinary retries unavailable responses with the whole file", func(t *testing.T) {
+		var bodies []string
+		testServer, attempts := newFailingServer(2, http.StatusServiceUnavailable, &bodies)
+		defer testServer.Close()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, Retries: 3, RetryBackoff: time.Millisecond})
+		testFile := createAndWriteTempFile(t, "content")
+		defer os.Remove(testFile.Name())
+
+		body, err := runner.sendBinary(context.Background(), testFile.Name(), runner.paramsFor(Target{Os: "linux", Arch: "amd64"}))
+		assert.NoError(t, err, "error should be nil")
+		defer body.Close()
+		assert.Equal(t, int32(3), *attempts, "upload should be attempted until it succeeds")
+		assert.Equal(t, []string{"content", "content", "content"}, bodies, "every attempt should send the whole file")
+	})
+
+	t.Run("sendBinary does not retry client errors", func(t *testing.T) {
+		var bodies []string
+		testServer, attempts := newFailingServer(1, http.StatusBadRequest, &bodies)
+		defer testServer.Close()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, Retries: 3, RetryBackoff: time.Millisecond})
+		testFile := createAndWriteTempFile(t, "content")
+		defer os.Remove(testFile.Name())
+
+		_, err := runner.sendBinary(context.Background(), testFile.Name(), runner.paramsFor(Target{Os: "linux", Arch: "amd64"}))
+		assert.Error(t, err, "error should not be nil")
+		assert.Equal(t, int32(1), *attempts, "upload should not be retried")
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 5, 14, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now), "seconds should be parsed")
+	assert.Equal(t, time.Minute, parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now), "dates should be parsed")
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now), "invalid values should be ignored")
+}
+
+func TestRunner_retryDelay(t *testing.T) {
+	t.Run("retryDelay caps Retry-After at the maximum backoff", func(t *testing.T) {
+		runner := newTestRunner(t, nil, Options{Retries: 3, RetryMaxBackoff: time.Second})
+		delay, ok := runner.retryDelay(0, &APIError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 5 * time.Hour})
+		assert.True(t, ok, "the error should be retried")
+		assert.Equal(t, time.Second, delay, "the delay should be capped")
+
+		delay, _ = runner.retryDelay(0, &APIError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 500 * time.Millisecond})
+		assert.Equal(t, 500*time.Millisecond, delay, "a shorter Retry-After should be honored")
+	})
+}
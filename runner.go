@@ -2,149 +2,534 @@ package forge
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/SekyrOrg/forge/openapi"
 	"github.com/sourcegraph/conc/iter"
 	"go.uber.org/zap"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 type TempBinary struct {
 	originalFilePath string
 	tempFilePath     *os.File
+	target           Target
+	params           *openapi.PostCreatorParams
+	source           Digest
+	beacon           Digest
+	warnings         []string
+	// cached is set when the beacon came from the cache
+	cached bool
+}
+
+// record copies what is known about the beacon into result
+func (b *TempBinary) record(result *Result) {
+	result.Target = b.target
+	result.Params = b.params
+	result.Source = b.source
+	result.Beacon = b.beacon
+	result.Warnings = b.warnings
 }
 
 type Runner struct {
-	logger *zap.Logger
-	args   *Args
-	client *openapi.Client
+	logger  *zap.Logger
+	opts    *Options
+	client  *openapi.Client
+	params  *openapi.PostCreatorParams
+	backups *BackupStore
+	cache   *Cache
+	limiter *rateLimiter
+	// beacons are the hashes of beacons created by previous runs
+	beacons map[string]bool
+	// earlier are the manifest entries of previous runs recording a beacon, carried forward into the manifest
+	earlier []ManifestEntry
+
+	// distlist is cached once it was fetched successfully, failed fetches are retried
+	distlistMu sync.Mutex
+	distlist   []Target
+
+	// running are the files executed by the running processes, read once when first needed
+	runningOnce sync.Once
+	running     map[fileID]bool
 }
 
-func NewRunner(logger *zap.Logger, args *Args) (*Runner, error) {
-	client, err := openapi.NewClient(args.CreatorUrl)
+func NewRunner(logger *zap.Logger, opts *Options) (*Runner, error) {
+	params, err := opts.BeaconOpts.toPostCreatorParams()
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := resolveCredentials(opts)
+	if err != nil {
+		return nil, err
+	}
+	client, err := openapi.NewClient(opts.CreatorUrl, openapi.WithRequestEditorFn(credentials.requestEditor()))
 	if err != nil {
 		return nil, fmt.Errorf("error creating client: %w", err)
 	}
+	if opts.Concurrency < 0 {
+		return nil, fmt.Errorf("%w, got %d", ErrInvalidConcurrency, opts.Concurrency)
+	}
+	if err := validateLinkPolicy(opts.LinkPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateLayout(opts.Layout); err != nil {
+		return nil, err
+	}
+	if err := validatePreserve(opts.Preserve); err != nil {
+		return nil, err
+	}
+	beacons := map[string]bool{}
+	var earlier []ManifestEntry
+	if previous := opts.previousManifest(); previous != "" {
+		if beacons, earlier, err = loadBeacons(previous); err != nil {
+			return nil, err
+		}
+	}
+	var backups *BackupStore
+	if opts.OutputFolder == "" && !opts.NoBackup {
+		if backups, err = OpenBackupStore(opts.BackupDir); err != nil {
+			return nil, err
+		}
+	}
+	var cache *Cache
+	if opts.CacheDir != "" && !opts.NoCache {
+		if cache, err = OpenCache(opts.CacheDir, opts.CacheTTL, opts.CacheMaxSize); err != nil {
+			return nil, err
+		}
+	}
 	return &Runner{
-		logger: logger,
-		args:   args,
-		client: client,
+		logger:  logger,
+		opts:    opts,
+		client:  client,
+		params:  params,
+		backups: backups,
+		cache:   cache,
+		limiter: newRateLimiter(opts.Rate),
+		beacons: beacons,
+		earlier: earlier,
 	}, nil
 }
 
-func (r *Runner) Run() error {
-	r.logger.With(zap.Any("arguments", r.args)).Debug("Starting Runner")
-	binaryFiles, err := iter.MapErr(r.args.FilePaths, r.CreateBinary)
-	if err != nil {
-		return fmt.Errorf("error creating temp binary, file: %s, error: %s", r.args.FilePaths, err)
-	}
+// Run creates a beacon for every file and installs them once all beacons have been created.
+// If any beacon cannot be created nothing is installed and the remaining files are marked with ErrAborted,
+// unless ContinueOnError is set in which case every file is processed independently.
+func (r *Runner) Run(ctx context.Context, filePaths []string) ([]*Result, error) {
+	r.logger.With(zap.Any("options", r.opts), zap.Strings("files", filePaths)).Debug("Starting Runner")
+	paths, links, excluded := r.resolveLinks(filePaths)
+	paths, links, conflicts := r.claimDestinations(paths, links)
+	results := make([]*Result, len(paths))
+	binaryFiles := make([]*TempBinary, len(paths))
+	shared := newUploads()
+	defer shared.remove()
+	workers := iter.Iterator[string]{MaxGoroutines: r.opts.Concurrency}
+	workers.ForEachIdx(paths, func(i int, filePath *string) {
+		results[i] = &Result{Path: *filePath}
+		binaryFiles[i], results[i].Err = r.createBinary(ctx, *filePath, shared)
+		if binaryFiles[i] != nil {
+			binaryFiles[i].record(results[i])
+		}
+		var skipped *SkipError
+		if errors.As(results[i].Err, &skipped) {
+			r.logger.With(zap.String("file", *filePath), zap.String("reason", skipped.Reason)).Info("Skipping file")
+			results[i].Err, results[i].SkipReason, results[i].Source = nil, skipped.Reason, skipped.source
+			if skipped.Reason == SkipBeacon {
+				results[i].Beacon = skipped.source
+			}
+		}
+	})
 	// delete all temp files once done
-	defer iter.ForEach(binaryFiles, func(filePointer **TempBinary) {
-		os.Remove((*filePointer).tempFilePath.Name())
+	defer func() {
+		for _, binary := range binaryFiles {
+			if binary != nil {
+				binary.tempFilePath.Close()
+				os.Remove(binary.tempFilePath.Name())
+			}
+		}
+	}()
+	failed := firstError(results, excluded, conflicts)
+	if err := failed; err != nil && !r.opts.ContinueOnError {
+		for i, binary := range binaryFiles {
+			if binary != nil {
+				results[i].Err = ErrAborted
+			}
+		}
+		results = inputOrder(filePaths, results, r.linkResults(links, byPath(results)), excluded, conflicts)
+		if err := r.writeManifest(results); err != nil {
+			r.logger.Error("error writing manifest", zap.Error(err))
+		}
+		return results, fmt.Errorf("error creating beacon: %w", err)
+	}
+
+	iter.ForEachIdx(binaryFiles, func(i int, binary **TempBinary) {
+		if *binary == nil {
+			return
+		}
+		results[i].Err = r.OverwriteBinary(*binary, results[i])
 	})
-	iter.ForEach(binaryFiles, r.OverwriteBinary)
+	results = inputOrder(filePaths, results, r.linkResults(links, byPath(results)), excluded, conflicts)
+	if err := r.writeManifest(results); err != nil {
+		return results, err
+	}
+	if failed := countFailed(results); failed > 0 {
+		return results, fmt.Errorf("%d of %d files failed, first error: %w", failed, len(results), firstError(results))
+	}
+	return results, nil
+}
+
+// byPath indexes results by the path of their file
+func byPath(results []*Result) map[string]*Result {
+	indexed := make(map[string]*Result, len(results))
+	for _, result := range results {
+		indexed[result.Path] = result
+	}
+	return indexed
+}
 
+// inputOrder returns the results in the order of the inputs, followed by the link targets that were not inputs
+func inputOrder(filePaths []string, groups ...[]*Result) []*Result {
+	indexed := map[string]*Result{}
+	for _, group := range groups {
+		for _, result := range group {
+			indexed[result.Path] = result
+		}
+	}
+	ordered := make([]*Result, 0, len(indexed))
+	for _, filePath := range filePaths {
+		if result, ok := indexed[filePath]; ok {
+			ordered = append(ordered, result)
+			delete(indexed, filePath)
+		}
+	}
+	for _, group := range groups {
+		for _, result := range group {
+			if _, ok := indexed[result.Path]; ok {
+				ordered = append(ordered, result)
+				delete(indexed, result.Path)
+			}
+		}
+	}
+	return ordered
+}
+
+// firstError returns the first error of the results
+func firstError(groups ...[]*Result) error {
+	for _, results := range groups {
+		for _, result := range results {
+			if result.Err != nil {
+				return result.Err
+			}
+		}
+	}
 	return nil
 }
 
+// countFailed returns the number of results with an error
+func countFailed(results []*Result) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
 // CreateBinary sends the binary to the beaconCreator and stores the beacon in a temporary file
 // Returns the path to the temporary file and the path to the original file
-func (r *Runner) CreateBinary(filePathPointer *string) (*TempBinary, error) {
-	filePath := filepath.Clean(*filePathPointer)
-	responseBody, err := r.sendBinary(filePath)
+func (r *Runner) CreateBinary(ctx context.Context, filePath string) (*TempBinary, error) {
+	return r.createBinary(ctx, filePath, nil)
+}
+
+// createBinary creates the beacon for filePath, sharing the upload with the identical files of the run in shared
+func (r *Runner) createBinary(ctx context.Context, filePath string, shared *uploads) (*TempBinary, error) {
+	filePath = filepath.Clean(filePath)
+	source, err := digestFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("error sending binary: %w", err)
+		return nil, &FileError{Path: filePath, Op: OpOpen, Err: err}
+	}
+	if err := r.guard(filePath); err != nil {
+		return nil, err
+	}
+	reason, err := r.skipReason(filePath, source)
+	if err != nil {
+		return nil, &FileError{Path: filePath, Op: OpOpen, Err: err}
+	}
+	if reason != "" {
+		return nil, &SkipError{Path: filePath, Reason: reason, source: source}
+	}
+	target, err := r.targetFor(filePath)
+	if err != nil {
+		return nil, err
+	}
+	params := r.paramsFor(target)
+	binary, err := r.sharedUpload(ctx, shared, filePath, source, target, params)
+	if err != nil {
+		return nil, err
+	}
+	binary.target = target
+	binary.params = params
+	binary.source = source
+	binary.warnings = r.warnings(filePath)
+	if len(binary.warnings) > 0 {
+		r.logger.With(zap.String("file", filePath), zap.Strings("warnings", binary.warnings)).Warn("Replacing a file that needs care")
+	}
+	if err := r.smokeTest(ctx, filePath, binary); err != nil {
+		if errors.Is(err, ErrSmokeTestDiverged) {
+			r.evictBeacon(filePath, binary)
+		}
+		removeTempBinary(binary)
+		return nil, err
+	}
+	r.cacheBeacon(filePath, binary)
+	return binary, nil
+}
+
+// uploadBinary checks the target and sends the file to the creator, writing the beacon to a temp file
+func (r *Runner) uploadBinary(ctx context.Context, filePath string, target Target, params *openapi.PostCreatorParams) (*TempBinary, error) {
+	if err := r.checkTarget(ctx, filePath, target); err != nil {
+		return nil, err
+	}
+	responseBody, err := r.sendBinary(ctx, filePath, params)
+	if err != nil {
+		return nil, err
 	}
 	defer responseBody.Close()
 
-	return r.createTempBinaryFile(filePath, responseBody)
+	binary, err := r.createTempBinaryFile(filePath, responseBody)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBeacon(responseBody, binary, target); err != nil {
+		removeTempBinary(binary)
+		return nil, &FileError{Path: filePath, Op: OpValidate, Err: err}
+	}
+	if gzipBody, ok := responseBody.ReadCloser.(*gzipResponseBody); ok {
+		r.logTransfer("Downloaded gzip compressed beacon", filePath, gzipBody.size, gzipBody.compressed.count)
+	}
+	return binary, nil
+}
+
+// paramsFor returns the creator parameters for a beacon built for target
+func (r *Runner) paramsFor(target Target) *openapi.PostCreatorParams {
+	params := *r.params
+	params.Os, params.Arch = target.Os, target.Arch
+	return &params
+}
+
+// targetFor returns the target the beacon for filePath is built for, detected from the headers of the file
+// unless ForceTarget is set, in which case the os and arch of the beacon options are used
+func (r *Runner) targetFor(filePath string) (Target, error) {
+	if r.opts.ForceTarget {
+		return Target{Os: r.opts.BeaconOpts.Os, Arch: r.opts.BeaconOpts.Arch}, nil
+	}
+	target, err := DetectTarget(filePath)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return Target{}, &FileError{Path: filePath, Op: OpOpen, Err: fmt.Errorf("error opening file: %w", err)}
+	}
+	if err != nil {
+		return Target{}, &FileError{Path: filePath, Op: OpDetect, Err: fmt.Errorf("error detecting os/arch, use --force-target to set them explicitly: %w", err)}
+	}
+	r.logger.With(zap.String("file", filePath), zap.Stringer("target", target)).Debug("Detected target")
+	return target, nil
 }
 
 // createTempBinaryFile creates a temporary file from the given response body
 func (r *Runner) createTempBinaryFile(filePath string, responseBody io.Reader) (*TempBinary, error) {
 	r.logger.With(zap.String("file", filePath)).Debug("Creating temp file")
-	tempDir := os.TempDir()
-	file := filepath.Base(filepath.Clean(filePath))
-	tempFile, err := os.CreateTemp(tempDir, file)
+	destination, err := r.getDestinationFilePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tempFile, err := createTempNear(destination)
 	if err != nil {
-		return nil, fmt.Errorf("error creating temp file, tempdir: %s, file: %s, err:  %w", tempDir, path.Base(filePath), err)
+		return nil, &FileError{Path: filePath, Op: OpCreateTemp, Err: err}
 	}
 
-	if _, err = io.Copy(tempFile, responseBody); err != nil {
-		return nil, fmt.Errorf("error copying binary to temp file: %w", err)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hasher), responseBody)
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return nil, &FileError{Path: filePath, Op: OpCreateTemp, Err: fmt.Errorf("error copying binary to temp file: %w", err)}
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return nil, &FileError{Path: filePath, Op: OpCreateTemp, Err: fmt.Errorf("error syncing temp file: %w", err)}
 	}
 
 	return &TempBinary{
 		originalFilePath: filePath,
 		tempFilePath:     tempFile,
+		beacon:           Digest{SHA256: hex.EncodeToString(hasher.Sum(nil)), Size: size},
 	}, nil
 }
 
 // OverwriteBinary overwrites the original binary with the beacon stored in the temporary file
-func (r *Runner) OverwriteBinary(filePointer **TempBinary) {
-	file := *filePointer
-	destination := r.getDestinationFilePath(file)
+// The destination of the beacon and the backup of the original, if one was taken, are recorded in result
+func (r *Runner) OverwriteBinary(file *TempBinary, result *Result) error {
+	destination, err := r.getDestinationFilePath(file.originalFilePath)
+	if err != nil {
+		return err
+	}
 	r.logger.
 		With(
 			zap.String("tempFilePath", file.tempFilePath.Name()),
 			zap.String("destinationFilePath", destination)).
 		Info("Overwriting binary")
-	if err := r.CopyFilePermissions(file.originalFilePath, file.tempFilePath); err != nil {
-		r.logger.Fatal(fmt.Sprintf("error copying file permissions: %s", err))
-		return
+	preserve := func(path string) (err error) {
+		result.Unpreserved, err = r.preserveAttributes(file.originalFilePath, path)
+		return err
+	}
+	if err := preserve(file.tempFilePath.Name()); err != nil {
+		return &FileError{Path: file.originalFilePath, Op: OpPermissions, Err: err}
+	}
+	if result.Backup, err = r.backupOriginal(file.originalFilePath, destination, file.beacon.SHA256); err != nil {
+		return err
 	}
 	file.tempFilePath.Close()
-	if err := os.Rename(file.tempFilePath.Name(), destination); err != nil {
-		r.logger.Fatal(fmt.Sprintf("error renaming temp file to original file: %s", err))
+	if err := installFile(file.tempFilePath.Name(), destination, preserve); err != nil {
+		return &FileError{Path: file.originalFilePath, Op: OpRename, Err: fmt.Errorf("error installing temp file at destination: %w", err)}
 	}
+	if len(result.Unpreserved) > 0 {
+		r.logger.With(zap.String("file", file.originalFilePath), zap.Strings("attributes", result.Unpreserved)).Warn("Some attributes were not preserved")
+	}
+	result.Destination = destination
+	result.InstalledAt = time.Now().UTC()
+	return nil
+}
+
+// backupOriginal keeps a copy of the original file when it is about to be overwritten
+// Returns the path of the copy, empty when no backup is needed
+func (r *Runner) backupOriginal(originalFilePath string, destination string, beacon string) (string, error) {
+	if r.backups == nil || destination != originalFilePath {
+		return "", nil
+	}
+	entry, err := r.backups.Backup(originalFilePath, beacon)
+	if err != nil {
+		return "", &FileError{Path: originalFilePath, Op: OpBackup, Err: err}
+	}
+	if entry.LinkTarget != "" {
+		// the link is recorded in the index, there is no copy
+		r.logger.With(zap.String("file", originalFilePath), zap.String("target", entry.LinkTarget)).Debug("Backed up original link")
+		return "", nil
+	}
+	r.logger.With(zap.String("file", originalFilePath), zap.String("sha256", entry.SHA256)).Debug("Backed up original")
+	return r.backups.BlobPath(entry), nil
 }
 
 func (r *Runner) checkResponseStatus(response *http.Response) (io.ReadCloser, error) {
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected response status: %s", response.Status)
+		defer response.Body.Close()
+		apiErr := newAPIError(response)
+		r.logger.
+			With(zap.Int("status", apiErr.StatusCode), zap.String("code", apiErr.Code), zap.String("message", apiErr.Message)).
+			Warn("Creator returned an error")
+		return nil, apiErr
 	}
 	return response.Body, nil
 }
 
 // getDestinationFilePath returns the path for the destination file, based on user-specified output folder
-func (r *Runner) getDestinationFilePath(file *TempBinary) string {
-	if r.args.OutputFolder == "" {
-		return file.originalFilePath
+func (r *Runner) getDestinationFilePath(originalFilePath string) (string, error) {
+	if r.opts.OutputFolder == "" {
+		return originalFilePath, nil
+	}
+	destination := r.destinationFor(originalFilePath)
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return "", &FileError{Path: originalFilePath, Op: OpMkdir, Err: fmt.Errorf("error creating output folder: %w", err)}
 	}
-	if err := os.MkdirAll(r.args.OutputFolder, 0755); err != nil {
-		r.logger.Fatal(fmt.Sprintf("error creating output folder: %s", err))
+	return destination, nil
+}
+
+// destinationFor returns where the beacon of the file is installed
+func (r *Runner) destinationFor(originalFilePath string) string {
+	if r.opts.OutputFolder == "" {
+		return originalFilePath
+	}
+	if r.opts.Layout == LayoutTree {
+		return treeDestination(r.opts.OutputFolder, originalFilePath)
+	}
+	return filepath.Join(r.opts.OutputFolder, filepath.Base(originalFilePath))
+}
+
+func (r *Runner) sendBinary(ctx context.Context, filepath string, params *openapi.PostCreatorParams) (*beaconBody, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := r.sendBinaryOnce(ctx, filepath, params)
+		if err == nil {
+			return body, nil
+		}
+		delay, retry := r.retryDelay(attempt, err)
+		if !retry {
+			return nil, err
+		}
+		r.logger.
+			With(zap.String("file", filepath), zap.Int("attempt", attempt+1), zap.Duration("delay", delay), zap.Error(err)).
+			Warn("Upload failed, retrying")
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, &FileError{Path: filepath, Op: OpUpload, Err: err}
+		}
 	}
-	return filepath.Join(r.args.OutputFolder, filepath.Base(file.originalFilePath))
 }
 
-// sendBinary sends the binary to the beaconCreator and returns the response body
-func (r *Runner) sendBinary(filepath string) (io.ReadCloser, error) {
+// sendBinaryOnce makes a single upload of the binary
+func (r *Runner) sendBinaryOnce(ctx context.Context, filepath string, params *openapi.PostCreatorParams) (*beaconBody, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, &FileError{Path: filepath, Op: OpUpload, Err: err}
+	}
 	binary, err := os.Open(filepath)
 	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
+		return nil, &FileError{Path: filepath, Op: OpOpen, Err: fmt.Errorf("error opening file: %w", err)}
 	}
 	defer binary.Close()
 
-	response, err := r.client.PostCreatorWithBody(context.Background(), r.args.BeaconOpts.toPostCreatorParams(), "application/octet-stream", binary)
+	var requestBody io.Reader = binary
+	var compressed *countingReader
+	var editors []openapi.RequestEditorFn
+	if r.opts.BeaconOpts.Gzip {
+		compressed = compressBody(binary)
+		requestBody = compressed
+		editors = append(editors, gzipEncoding)
+	}
+	response, err := r.client.PostCreatorWithBody(ctx, params, "application/octet-stream", requestBody, editors...)
 	if err != nil {
-		return nil, fmt.Errorf("error sending binary: %w", err)
+		return nil, &FileError{Path: filepath, Op: OpUpload, Err: fmt.Errorf("error sending binary: %w", err)}
+	}
+	if compressed != nil {
+		if info, err := binary.Stat(); err == nil {
+			r.logTransfer("Uploaded gzip compressed binary", filepath, info.Size(), compressed.count)
+		}
 	}
 
-	return r.checkResponseStatus(response)
-}
-
-// CopyFilePermissions copies the file permissions from the original file to the temporary file
-func (r *Runner) CopyFilePermissions(originalFile string, tempfile *os.File) error {
-	originalFileInfo, err := os.Stat(originalFile)
+	body, err := r.checkResponseStatus(response)
+	if err != nil {
+		return nil, &FileError{Path: filepath, Op: OpUpload, Err: err}
+	}
+	checksum, err := responseChecksum(response.Header)
 	if err != nil {
-		return fmt.Errorf("error getting file info: %w", err)
+		body.Close()
+		return nil, &FileError{Path: filepath, Op: OpValidate, Err: err}
 	}
-	if err := tempfile.Chmod(originalFileInfo.Mode()); err != nil {
-		return fmt.Errorf("error changing file permissions: %w", err)
+	raw := &countingReader{ReadCloser: body}
+	decompressed, err := decompressBody(raw, response.Header)
+	if err != nil {
+		return nil, &FileError{Path: filepath, Op: OpUpload, Err: fmt.Errorf("error decompressing response: %w", err)}
 	}
-	return nil
+	return &beaconBody{ReadCloser: decompressed, raw: raw, contentLength: response.ContentLength, checksum: checksum}, nil
+}
+
+// logTransfer logs the bytes saved by compressing a transfer
+func (r *Runner) logTransfer(message string, filepath string, size int64, compressed int64) {
+	r.logger.
+		With(zap.String("file", filepath), zap.Int64("size", size), zap.Int64("compressed", compressed), zap.Int64("saved", size-compressed)).
+		Debug(message)
 }
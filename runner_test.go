@@ -1,6 +1,10 @@
 package forge
 
 import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -13,66 +17,60 @@ import (
 )
 
 func TestRunner_sendBinary(t *testing.T) {
-	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("testServer got request %s\n", r.URL)
-		w.Write([]byte("test"))
-	}))
+	testServer := httptest.NewUnstartedServer(testCreatorHandler("test"))
 	testServer.Start()
 	defer testServer.Close()
 	logger, err := zap.NewDevelopment()
 	assert.NoError(t, err)
 
 	t.Run("Runner_sendBinary works", func(t *testing.T) {
-		runner := Runner{logger: logger, args: &Args{CreatorUrl: testServer.URL}}
+		runner := newTestRunner(t, logger, Options{CreatorUrl: testServer.URL})
 		testFile := createAndWriteTempFile(t, "test")
 		defer os.Remove(testFile.Name())
 
-		r, err := runner.sendBinary(testFile.Name())
+		r, err := runner.sendBinary(context.Background(), testFile.Name(), runner.paramsFor(Target{Os: "linux", Arch: "amd64"}))
 		assert.NoError(t, err)
 		assert.NotNil(t, r)
 		content, err := io.ReadAll(r)
 		assert.NoError(t, err)
-		assert.Equal(t, content, []byte("test"), "content of returned reader should be content returned by testServer")
+		assert.Equal(t, content, testELF("test"), "content of returned reader should be content returned by testServer")
 	})
 }
 
 func TestRunner_CreateBinary(t *testing.T) {
-	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("testServer got request %s\n", r.URL)
-		w.Write([]byte("test"))
-	}))
+	testServer := httptest.NewUnstartedServer(testCreatorHandler("test"))
 	testServer.Start()
 	defer testServer.Close()
 	logger, err := zap.NewDevelopment()
 	assert.NoError(t, err)
 
 	t.Run("Runner_CreateBinary works", func(t *testing.T) {
-		runner := Runner{logger: logger, args: &Args{CreatorUrl: testServer.URL}}
-		testFile := createAndWriteTempFile(t, "test")
+		runner := newTestRunner(t, logger, Options{CreatorUrl: testServer.URL})
+		testFile := createTestExecutable(t, "test")
 		defer os.Remove(testFile.Name())
-		filename := testFile.Name()
-		binary, err := runner.CreateBinary(&filename)
+		binary, err := runner.CreateBinary(context.Background(), testFile.Name())
 		assert.NoError(t, err, "error should be nil")
 		assert.NotNil(t, binary, "binary should not be nil")
 		assert.NotEmpty(t, binary.originalFilePath, "originalFilePath should be set")
-		assert.Equal(t, binary.originalFilePath, testFile, "originalFilePath should be set to testFile")
+		assert.Equal(t, binary.originalFilePath, testFile.Name(), "originalFilePath should be set to testFile")
 		assert.NotEmpty(t, binary.tempFilePath, "tempFilePath should be set")
 		assert.NotNil(t, binary.tempFilePath, "tempFile should not be nil")
-
+		assert.Equal(t, Target{Os: "linux", Arch: "amd64"}, binary.target, "target should be detected from the file")
+		os.Remove(binary.tempFilePath.Name())
 	})
 	t.Run("Runner_CreateBinary tempfile contains the content sent from testServer", func(t *testing.T) {
-		runner := Runner{logger: logger, args: &Args{CreatorUrl: testServer.URL}}
-		testFile := createAndWriteTempFile(t, "test")
+		runner := newTestRunner(t, logger, Options{CreatorUrl: testServer.URL})
+		testFile := createTestExecutable(t, "test")
 		defer os.Remove(testFile.Name())
-		filename := testFile.Name()
 
-		binary, err := runner.CreateBinary(&filename)
+		binary, err := runner.CreateBinary(context.Background(), testFile.Name())
 		assert.NoError(t, err, "error should be nil")
 		assert.NotNil(t, binary, "binary should not be nil")
 		assert.NotNil(t, binary.tempFilePath, "tempFile should not be nil")
-		tempFileContend, err := io.ReadAll(binary.tempFilePath)
+		defer os.Remove(binary.tempFilePath.Name())
+		tempFileContend, err := os.ReadFile(binary.tempFilePath.Name())
 		assert.NoError(t, err, "error should be nil")
-		assert.Equal(t, tempFileContend, []byte("test"), "content of temp file should be content returned by testServer")
+		assert.Equal(t, tempFileContend, testELF("test"), "content of temp file should be content returned by testServer")
 	})
 }
 
@@ -81,9 +79,9 @@ func TestRunner_OverwriteBinary(t *testing.T) {
 	assert.NoError(t, err)
 	t.Run("Runner_OverwriteBinary overwrites destination with tempFile ", func(t *testing.T) {
 
-		runner := Runner{logger: logger, args: &Args{}}
+		runner := newTestRunner(t, logger, Options{})
 		// create temp file
-		tempFile := createAndWriteTempFile(t, "temp")
+		tempFile := openTempFile(t, createAndWriteTempFile(t, "temp"))
 		defer os.Remove(tempFile.Name())
 		destinationFile := createAndWriteTempFile(t, "destination")
 		defer os.Remove(destinationFile.Name())
@@ -92,22 +90,24 @@ func TestRunner_OverwriteBinary(t *testing.T) {
 			originalFilePath: destinationFile.Name(),
 			tempFilePath:     tempFile,
 		}
-		runner.OverwriteBinary(&tempBinary)
-		tempFileContend, err := io.ReadAll(tempFile)
+		result := &Result{}
+		err := runner.OverwriteBinary(tempBinary, result)
 		assert.NoError(t, err, "error should be nil")
-		destinationFileContend, err := io.ReadAll(destinationFile)
+		assert.Equal(t, destinationFile.Name(), result.Destination, "destination should be the original file")
+		assert.FileExists(t, result.Backup, "the original should be backed up")
+		destinationFileContend, err := os.ReadFile(destinationFile.Name())
 		assert.NoError(t, err, "error should be nil")
-		assert.Equal(t, tempFileContend, destinationFileContend, "tempFile and destinationFile should be equal")
+		assert.Equal(t, []byte("temp"), destinationFileContend, "tempFile and destinationFile should be equal")
 	})
 
 	t.Run("Runner_OverwriteBinary temp written to outFolder if specified ", func(t *testing.T) {
 
 		outdir, err := os.MkdirTemp(os.TempDir(), "outDir")
 		assert.NoError(t, err, "error should be nil")
-		runner := Runner{logger: logger, args: &Args{OutputFolder: outdir}}
+		runner := newTestRunner(t, logger, Options{OutputFolder: outdir})
 		// create temp file
 
-		tempFile := createAndWriteTempFile(t, "temp")
+		tempFile := openTempFile(t, createAndWriteTempFile(t, "temp"))
 		defer os.Remove(tempFile.Name())
 		destinationFile := createAndWriteTempFile(t, "destination")
 		defer os.Remove(destinationFile.Name())
@@ -116,22 +116,21 @@ func TestRunner_OverwriteBinary(t *testing.T) {
 			originalFilePath: destinationFile.Name(),
 			tempFilePath:     tempFile,
 		}
-		runner.OverwriteBinary(&tempBinary)
-
-		tempFileContend, err := io.ReadAll(tempFile)
+		err = runner.OverwriteBinary(tempBinary, &Result{})
 		assert.NoError(t, err, "error should be nil")
+
 		outDirDestinationPath := filepath.Join(outdir, filepath.Base(destinationFile.Name()))
 		outDirDestinationFileContend, err := os.ReadFile(outDirDestinationPath)
 		assert.NoError(t, err, "error should be nil")
-		assert.Equal(t, tempFileContend, outDirDestinationFileContend, "tempFile and outDirDestinationFile should be equal")
+		assert.Equal(t, []byte("temp"), outDirDestinationFileContend, "tempFile and outDirDestinationFile should be equal")
 	})
 	t.Run("Runner_OverwriteBinary destination file not overwritten when utFolder if specified ", func(t *testing.T) {
 
 		outdir, err := os.MkdirTemp(os.TempDir(), "outDir")
 		assert.NoError(t, err, "error should be nil")
-		runner := Runner{logger: logger, args: &Args{OutputFolder: outdir}}
+		runner := newTestRunner(t, logger, Options{OutputFolder: outdir})
 		// create temp file
-		tempFile := createAndWriteTempFile(t, "temp")
+		tempFile := openTempFile(t, createAndWriteTempFile(t, "temp"))
 		defer os.Remove(tempFile.Name())
 		destinationFile := createAndWriteTempFile(t, "destination")
 		defer os.Remove(destinationFile.Name())
@@ -140,13 +139,12 @@ func TestRunner_OverwriteBinary(t *testing.T) {
 			originalFilePath: destinationFile.Name(),
 			tempFilePath:     tempFile,
 		}
-		runner.OverwriteBinary(&tempBinary)
-
-		tempFileContend, err := io.ReadAll(tempFile)
+		err = runner.OverwriteBinary(tempBinary, &Result{})
 		assert.NoError(t, err, "error should be nil")
-		destinationFileContend, err := io.ReadAll(destinationFile)
+
+		destinationFileContend, err := os.ReadFile(destinationFile.Name())
 		assert.NoError(t, err, "error should be nil")
-		assert.NotEqual(t, tempFileContend, destinationFileContend, "tempFile and destinationFile should be equal")
+		assert.NotEqual(t, []byte("temp"), destinationFileContend, "tempFile and destinationFile should be equal")
 	})
 }
 
@@ -160,31 +158,85 @@ func createAndWriteTempFile(t *testing.T, nameAndContent string) *os.File {
 	return tempFile
 }
 
-func TestRunner_Run(t *testing.T) {
-	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// testCreatorHandler serves a distlist with linux/amd64 and responds to every other request with a linux/amd64 beacon ending in beacon
+func testCreatorHandler(beacon string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("testServer got request %s\n", r.URL)
-		w.Write([]byte("test"))
-	}))
+		if r.URL.Path == "/creator/distlist" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"os":"linux","arch":"x86_64"},{"os":"darwin","arch":"arm64"}]`))
+			return
+		}
+		w.Write(testELF(beacon))
+	}
+}
+
+// createTestExecutable creates a file with a linux/amd64 ELF header followed by content
+func createTestExecutable(t *testing.T, content string) *os.File {
+	t.Helper()
+	tempFile := createAndWriteTempFile(t, content)
+	assert.NoError(t, os.WriteFile(tempFile.Name(), testELF(content), 0755), "error should be nil")
+	return tempFile
+}
+
+// testELF returns a minimal linux/amd64 ELF executable followed by content
+func testELF(content string) []byte {
+	header := elf.Header64{Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT), Ehsize: 64}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, header)
+	buffer.WriteString(content)
+	return buffer.Bytes()
+}
+
+// openTempFile reopens a file created by createAndWriteTempFile for reading and writing
+func openTempFile(t *testing.T, file *os.File) *os.File {
+	t.Helper()
+	openFile, err := os.OpenFile(file.Name(), os.O_RDWR, 0)
+	assert.NoError(t, err, "error should be nil")
+	return openFile
+}
+
+func newTestRunner(t *testing.T, logger *zap.Logger, opts Options) *Runner {
+	t.Helper()
+	isolateEnv(t)
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if opts.BackupDir == "" {
+		opts.BackupDir = t.TempDir()
+	}
+	runner, err := NewRunner(logger, &opts)
+	assert.NoError(t, err, "error should be nil")
+	return runner
+}
+
+func TestRunner_Run(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(testCreatorHandler("test"))
 	testServer.Start()
 	defer testServer.Close()
 	logger, err := zap.NewDevelopment()
 	assert.NoError(t, err)
 	t.Run("Runner_Run creates binary from path and overwrites it", func(t *testing.T) {
-		tempFile1 := createAndWriteTempFile(t, "temp1")
+		tempFile1 := createTestExecutable(t, "temp1")
 		defer os.Remove(tempFile1.Name())
-		tempFile2 := createAndWriteTempFile(t, "temp2")
+		tempFile2 := createTestExecutable(t, "temp2")
 		defer os.Remove(tempFile2.Name())
-		runner := Runner{logger: logger, args: &Args{CreatorUrl: testServer.URL, FilePaths: []string{tempFile1.Name(), tempFile2.Name()}}}
-		err := runner.Run()
+		runner := newTestRunner(t, logger, Options{CreatorUrl: testServer.URL})
+		results, err := runner.Run(context.Background(), []string{tempFile1.Name(), tempFile2.Name()})
 		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, results, 2, "there should be a result for every file")
 		assert.NotNil(t, tempFile1, "tempFile1 should not be nil")
 		assert.NotNil(t, tempFile2, "tempFile2 should not be nil")
-		tempFile1Content, err := io.ReadAll(tempFile1)
+		tempFile1Content, err := os.ReadFile(tempFile1.Name())
 		assert.NoError(t, err, "error should be nil")
-		assert.Equal(t, tempFile1Content, []byte("test"), "content of tempFile1 should be content returned by testServer")
+		assert.Equal(t, tempFile1Content, testELF("test"), "content of tempFile1 should be content returned by testServer")
 
-		tempFile2Content, err := io.ReadAll(tempFile2)
+		tempFile2Content, err := os.ReadFile(tempFile2.Name())
 		assert.NoError(t, err, "error should be nil")
-		assert.Equal(t, tempFile2Content, []byte("test"), "content of tempFile2 should be content returned by testServer")
+		assert.Equal(t, tempFile2Content, testELF("test"), "content of tempFile2 should be content returned by testServer")
 	})
 }
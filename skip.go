@@ -0,0 +1,93 @@
+package forge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reasons for skipping a file
+const (
+	SkipNotExecutable = "not an executable"
+	SkipScript        = "script"
+	SkipBeacon        = "already a beacon"
+	SkipSymlink       = "symlink"
+	SkipHardLink      = "hard link"
+)
+
+// executableMagics are the first bytes of ELF, Mach-O and PE executables
+var executableMagics = [][]byte{
+	{0x7f, 'E', 'L', 'F'},
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe},
+	{'M', 'Z'},
+}
+
+// SkipError is returned for files that are deliberately not converted, they are not counted as failures
+type SkipError struct {
+	Path   string
+	Reason string
+	source Digest
+}
+
+func (e *SkipError) Error() string {
+	return fmt.Sprintf("skipped %s: %s", e.Path, e.Reason)
+}
+
+// sniffExecutable returns the reason to skip the file at path, empty if it is an ELF, Mach-O or PE executable
+func sniffExecutable(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, 4)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	header = header[:n]
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(header, magic) {
+			return "", nil
+		}
+	}
+	if bytes.HasPrefix(header, []byte("#!")) {
+		return SkipScript, nil
+	}
+	return SkipNotExecutable, nil
+}
+
+// loadBeacons returns the hashes of the beacons recorded in a previous manifest and the entries recording them
+func loadBeacons(path string) (map[string]bool, []ManifestEntry, error) {
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := map[string]bool{}
+	var entries []ManifestEntry
+	for _, entry := range manifest.Entries {
+		if entry.After.SHA256 != "" {
+			hashes[entry.After.SHA256] = true
+			entries = append(entries, entry)
+		}
+	}
+	return hashes, entries, nil
+}
+
+// skipReason returns why the file should not be converted, empty if it should be
+func (r *Runner) skipReason(filePath string, source Digest) (string, error) {
+	reason, err := sniffExecutable(filePath)
+	if err != nil || reason != "" {
+		return reason, err
+	}
+	if r.beacons[source.SHA256] {
+		return SkipBeacon, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,102 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniffExecutable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		reason  string
+	}{
+		{"elf", "\x7fELF\x02\x01\x01", ""},
+		{"macho", "\xcf\xfa\xed\xfe\x07", ""},
+		{"fat macho", "\xca\xfe\xba\xbe\x00", ""},
+		{"pe", "MZ\x90\x00", ""},
+		{"shell script", "#!/bin/sh\necho hi\n", SkipScript},
+		{"text", "hello world", SkipNotExecutable},
+		{"empty", "", SkipNotExecutable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0755), "error should be nil")
+			reason, err := sniffExecutable(path)
+			assert.NoError(t, err, "error should be nil")
+			assert.Equal(t, tt.reason, reason, "reason should match")
+		})
+	}
+}
+
+func TestForge_Skip(t *testing.T) {
+	testServer := httptest.NewServer(testCreatorHandler("\x7fELF beacon"))
+	defer testServer.Close()
+
+	t.Run("Forge skips scripts without failing the batch", func(t *testing.T) {
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir()})
+		assert.NoError(t, err, "error should be nil")
+		script := filepath.Join(t.TempDir(), "script")
+		assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"), 0755), "error should be nil")
+
+		results, err := f.Forge(context.Background(), []string{script})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, StatusSkipped, results[0].Status(), "script should be skipped")
+		assert.Equal(t, SkipScript, results[0].Cause(), "cause should be the skip reason")
+		assert.Empty(t, results[0].Destination, "nothing should be installed")
+	})
+
+	t.Run("Forge skips beacons recorded in a previous manifest", func(t *testing.T) {
+		outdir := t.TempDir()
+		manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+		f, err := New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, ManifestPath: manifestPath})
+		assert.NoError(t, err, "error should be nil")
+		testFile := createTestExecutable(t, "test")
+		defer os.Remove(testFile.Name())
+		results, err := f.Forge(context.Background(), []string{testFile.Name()})
+		assert.NoError(t, err, "error should be nil")
+		beacon := results[0].Destination
+
+		f, err = New(nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), ManifestPath: manifestPath})
+		assert.NoError(t, err, "error should be nil")
+		results, err = f.Forge(context.Background(), []string{beacon})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, StatusSkipped, results[0].Status(), "beacon should be skipped")
+		assert.Equal(t, SkipBeacon, results[0].SkipReason, "reason should be SkipBeacon")
+
+		manifest, err := ReadManifest(manifestPath)
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, results[0].Source.SHA256, manifest.Entries[0].After.SHA256, "the beacon hash should be kept for later runs")
+	})
+
+	t.Run("Forge keeps skipping beacons of runs before the previous one", func(t *testing.T) {
+		otherServer := httptest.NewServer(testCreatorHandler("\x7fELF other beacon"))
+		defer otherServer.Close()
+		manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+		a, b := createTestExecutable(t, "a"), createTestExecutable(t, "b")
+		defer os.Remove(a.Name())
+		defer os.Remove(b.Name())
+		run := func(url string, path string) *Result {
+			f, err := New(nil, Options{CreatorUrl: url, BackupDir: t.TempDir(), ManifestPath: manifestPath})
+			assert.NoError(t, err, "error should be nil")
+			results, err := f.Forge(context.Background(), []string{path})
+			assert.NoError(t, err, "error should be nil")
+			return results[0]
+		}
+
+		assert.Equal(t, StatusConverted, run(testServer.URL, a.Name()).Status(), "a should be converted in place")
+		assert.Equal(t, StatusConverted, run(otherServer.URL, b.Name()).Status(), "b should be converted in place")
+		result := run(otherServer.URL, a.Name())
+		assert.Equal(t, StatusSkipped, result.Status(), "a should still be skipped")
+		assert.Equal(t, SkipBeacon, result.SkipReason, "reason should be SkipBeacon")
+
+		manifest, err := ReadManifest(manifestPath)
+		assert.NoError(t, err, "error should be nil")
+		assert.Len(t, manifest.Entries, 2, "the manifest should record the beacons of both files once")
+	})
+}
@@ -0,0 +1,138 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// DefaultSmokeTimeout is how long each run of a smoke test may take when no timeout is configured
+const DefaultSmokeTimeout = 5 * time.Second
+
+// ErrSmokeTestDiverged is returned when a beacon does not behave like its original in the smoke test
+var ErrSmokeTestDiverged = errors.New("beacon behaves differently from the original")
+
+// smokeRun is the outcome of running an executable in the smoke test
+type smokeRun struct {
+	exitCode int
+	stdout   []byte
+}
+
+// isHostTarget reports whether executables for target can run on this host
+func isHostTarget(target Target) bool {
+	return normalizeTargetName(target.Os) == runtime.GOOS && normalizeTargetName(target.Arch) == runtime.GOARCH
+}
+
+// smokeTest runs the original and the beacon with the smoke test arguments and compares their exit code and stdout.
+// Beacons for another os or arch than the host are not tested.
+func (r *Runner) smokeTest(ctx context.Context, filePath string, binary *TempBinary) error {
+	if !r.opts.SmokeTest {
+		return nil
+	}
+	logger := r.logger.With(zap.String("file", filePath), zap.Strings("args", r.opts.SmokeArgs))
+	if !isHostTarget(binary.target) {
+		logger.With(zap.Stringer("target", binary.target)).Info("Skipping smoke test, the target does not match the host")
+		return nil
+	}
+	scratch, err := os.MkdirTemp("", "forge-smoke-*")
+	if err != nil {
+		return &FileError{Path: filePath, Op: OpSmokeTest, Err: err}
+	}
+	defer os.RemoveAll(scratch)
+
+	name := filepath.Base(filePath)
+	original, err := r.smokeRun(ctx, filePath, filepath.Join(scratch, "original", name))
+	if err != nil {
+		return &FileError{Path: filePath, Op: OpSmokeTest, Err: fmt.Errorf("error running the original: %w", err)}
+	}
+	beacon, err := r.smokeRun(ctx, binary.tempFilePath.Name(), filepath.Join(scratch, "beacon", name))
+	if err != nil && ctx.Err() != nil {
+		return &FileError{Path: filePath, Op: OpSmokeTest, Err: err}
+	}
+	if err != nil {
+		return &FileError{Path: filePath, Op: OpSmokeTest, Err: fmt.Errorf("%w: %s", ErrSmokeTestDiverged, err)}
+	}
+	switch {
+	case beacon.exitCode != original.exitCode:
+		err = fmt.Errorf("%w: exit code %d, the original exits with %d", ErrSmokeTestDiverged, beacon.exitCode, original.exitCode)
+	case !bytes.Equal(beacon.stdout, original.stdout):
+		err = fmt.Errorf("%w: stdout differs from the original", ErrSmokeTestDiverged)
+	}
+	if err != nil {
+		return &FileError{Path: filePath, Op: OpSmokeTest, Err: err}
+	}
+	logger.With(zap.Int("exitCode", beacon.exitCode)).Info("Smoke test passed")
+	return nil
+}
+
+// smokeRun copies the executable to name, keeping the name of the original so multi-call binaries dispatch
+// the same way, and runs it with the smoke test arguments, an empty stdin and a minimal environment
+func (r *Runner) smokeRun(ctx context.Context, executable string, name string) (*smokeRun, error) {
+	workDir := filepath.Join(filepath.Dir(name), "work")
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := copyExecutable(executable, name); err != nil {
+		return nil, err
+	}
+
+	timeout := r.opts.SmokeTimeout
+	if timeout <= 0 {
+		timeout = DefaultSmokeTimeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	var cmd *exec.Cmd
+	var err error
+	// a concurrent fork can briefly hold the freshly written executable open, retry instead of failing
+	for attempt := 0; attempt < 5; attempt++ {
+		stdout.Reset()
+		cmd = exec.CommandContext(runCtx, name, r.opts.SmokeArgs...)
+		cmd.Dir = workDir
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + workDir, "TMPDIR=" + workDir}
+		cmd.Stdout = &stdout
+		if err = cmd.Run(); !errors.Is(err, syscall.ETXTBSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if runCtx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	return &smokeRun{exitCode: cmd.ProcessState.ExitCode(), stdout: stdout.Bytes()}, nil
+}
+
+// copyExecutable copies source to destination, only executable by the current user
+func copyExecutable(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,104 @@
+package forge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// serveExecutable returns a test server responding to uploads with the content of executable
+func serveExecutable(t *testing.T, executable string) *httptest.Server {
+	t.Helper()
+	content, err := os.ReadFile(executable)
+	if err != nil {
+		t.Skipf("%s is not available: %s", executable, err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+}
+
+// copyHostExecutable copies executable into a temp folder, keeping its name
+func copyHostExecutable(t *testing.T, executable string) string {
+	t.Helper()
+	content, err := os.ReadFile(executable)
+	if err != nil {
+		t.Skipf("%s is not available: %s", executable, err)
+	}
+	path := filepath.Join(t.TempDir(), filepath.Base(executable))
+	assert.NoError(t, os.WriteFile(path, content, 0755), "error should be nil")
+	return path
+}
+
+func TestRunner_SmokeTest(t *testing.T) {
+	t.Run("a beacon behaving like the original is installed", func(t *testing.T) {
+		testServer := serveExecutable(t, "/bin/echo")
+		defer testServer.Close()
+		original := copyHostExecutable(t, "/bin/echo")
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), SkipPreflight: true, SmokeTest: true, SmokeArgs: []string{"hello"}})
+
+		results, err := runner.Run(context.Background(), []string{original})
+		assert.NoError(t, err, "error should be nil")
+		assert.Equal(t, StatusConverted, results[0].Status(), "the file should be converted")
+	})
+
+	t.Run("a beacon with a different exit code is not installed", func(t *testing.T) {
+		testServer := serveExecutable(t, "/bin/false")
+		defer testServer.Close()
+		original := copyHostExecutable(t, "/bin/true")
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, SkipPreflight: true, SmokeTest: true})
+
+		results, err := runner.Run(context.Background(), []string{original})
+		assert.ErrorIs(t, err, ErrSmokeTestDiverged, "error should be ErrSmokeTestDiverged")
+		assert.ErrorIs(t, results[0].Err, ErrSmokeTestDiverged, "error should be ErrSmokeTestDiverged")
+		assert.Contains(t, results[0].Cause(), "exit code 1", "cause should contain the exit code")
+		assert.NoFileExists(t, filepath.Join(outdir, "true"), "the beacon should not be installed")
+		assertNoTempFiles(t, outdir)
+	})
+
+	t.Run("only beacons passing the smoke test are cached", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		for _, test := range []struct {
+			beacon  string
+			entries int
+		}{{"/bin/false", 0}, {"/bin/true", 1}} {
+			testServer := serveExecutable(t, test.beacon)
+			original := copyHostExecutable(t, "/bin/true")
+			runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: t.TempDir(), SkipPreflight: true, SmokeTest: true, CacheDir: cacheDir})
+			runner.Run(context.Background(), []string{original})
+			testServer.Close()
+
+			cache, err := OpenCache(cacheDir, 0, 0)
+			assert.NoError(t, err, "error should be nil")
+			entries, err := cache.Entries()
+			assert.NoError(t, err, "error should be nil")
+			assert.Len(t, entries, test.entries, "the cache should only contain beacons passing the smoke test")
+		}
+	})
+
+	t.Run("a beacon with a different stdout is not installed", func(t *testing.T) {
+		testServer := serveExecutable(t, "/bin/true")
+		defer testServer.Close()
+		original := copyHostExecutable(t, "/bin/echo")
+		outdir := t.TempDir()
+		runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, SkipPreflight: true, SmokeTest: true, SmokeArgs: []string{"hello"}})
+
+		results, err := runner.Run(context.Background(), []string{original})
+		assert.ErrorIs(t, err, ErrSmokeTestDiverged, "error should be ErrSmokeTestDiverged")
+		assert.Contains(t, results[0].Cause(), "stdout", "cause should mention stdout")
+		assert.NoFileExists(t, filepath.Join(outdir, "echo"), "the beacon should not be installed")
+	})
+}
+
+func TestIsHostTarget(t *testing.T) {
+	t.Run("only targets of the host are smoke tested", func(t *testing.T) {
+		assert.True(t, isHostTarget(Target{Os: "linux", Arch: runtime.GOARCH}), "the host should be a host target")
+		assert.False(t, isHostTarget(Target{Os: "windows", Arch: runtime.GOARCH}), "another os should not be a host target")
+	})
+}
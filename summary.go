@@ -0,0 +1,78 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Result statuses reported in the summary
+const (
+	StatusConverted = "converted"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+	StatusAborted   = "aborted"
+)
+
+// Status returns the status of the result, one of StatusConverted, StatusSkipped, StatusFailed or StatusAborted
+func (r *Result) Status() string {
+	switch {
+	case r.SkipReason != "":
+		return StatusSkipped
+	case r.Err == nil:
+		return StatusConverted
+	case errors.Is(r.Err, ErrAborted):
+		return StatusAborted
+	default:
+		return StatusFailed
+	}
+}
+
+// Cause returns a short description of why the file was not converted,
+// or of the attributes that were not preserved if it was
+func (r *Result) Cause() string {
+	var fileErr *FileError
+	if errors.As(r.Err, &fileErr) {
+		return fmt.Sprintf("%s: %s", fileErr.Op, fileErr.Err)
+	}
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	var notes []string
+	if len(r.Unpreserved) > 0 {
+		notes = append(notes, "not preserved: "+strings.Join(r.Unpreserved, ", "))
+	}
+	if len(r.Warnings) > 0 {
+		notes = append(notes, "warning: "+strings.Join(r.Warnings, ", "))
+	}
+	if r.SkipReason != "" {
+		notes = append(notes, r.SkipReason)
+	}
+	return strings.Join(notes, "; ")
+}
+
+// WriteSummary writes a table with the status of every result followed by the totals and,
+// if opts is not nil, the mode of the run
+func WriteSummary(w io.Writer, results []*Result, opts *Options) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "FILE\tSTATUS\tDESTINATION\tCAUSE")
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status()]++
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.Path, result.Status(), result.Destination, result.Cause())
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	converted, skipped := counts[StatusConverted], counts[StatusSkipped]
+	if _, err := fmt.Fprintf(w, "\n%d converted, %d skipped, %d not converted, %d total\n", converted, skipped, len(results)-converted-skipped, len(results)); err != nil {
+		return err
+	}
+	if opts == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "mode: %s\n", opts.describeMode())
+	return err
+}
@@ -0,0 +1,80 @@
+package forge
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrInvalidBeacon is returned when the creator responds with something that is not the requested beacon
+var ErrInvalidBeacon = errors.New("invalid beacon")
+
+// ChecksumHeader is the response header with the hex SHA-256 of the beacon.
+// The sha-256 values of the standard Digest and Content-Digest headers are checked as well.
+const ChecksumHeader = "X-Checksum-Sha256"
+
+// beaconBody is the decoded response body of the creator together with what is needed to validate it
+type beaconBody struct {
+	io.ReadCloser
+	// raw counts the bytes received, before decompression
+	raw *countingReader
+	// contentLength is the Content-Length of the response, -1 if unknown
+	contentLength int64
+	// checksum is the hex SHA-256 announced by the response headers, empty if none
+	checksum string
+}
+
+// responseChecksum returns the hex SHA-256 announced in the headers, empty if there is none
+func responseChecksum(header http.Header) (string, error) {
+	if value := header.Get(ChecksumHeader); value != "" {
+		if _, err := hex.DecodeString(value); err != nil || len(value) != 64 {
+			return "", fmt.Errorf("%w: malformed %s header %q", ErrInvalidBeacon, ChecksumHeader, value)
+		}
+		return strings.ToLower(value), nil
+	}
+	// Content-Digest: sha-256=:<base64>: (RFC 9530) and Digest: SHA-256=<base64> (RFC 3230)
+	for _, name := range []string{"Content-Digest", "Digest"} {
+		for _, digest := range strings.Split(header.Get(name), ",") {
+			algorithm, value, ok := strings.Cut(strings.TrimSpace(digest), "=")
+			if !ok || !strings.EqualFold(algorithm, "sha-256") {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			if err != nil || len(sum) != 32 {
+				return "", fmt.Errorf("%w: malformed %s header %q", ErrInvalidBeacon, name, digest)
+			}
+			return hex.EncodeToString(sum), nil
+		}
+	}
+	return "", nil
+}
+
+// validateBeacon checks that the beacon received in body and written to binary is complete,
+// matches the announced checksum and is an executable for the requested target
+func validateBeacon(body *beaconBody, binary *TempBinary, target Target) error {
+	if body.contentLength >= 0 && body.raw.count != body.contentLength {
+		return fmt.Errorf("%w: received %d bytes, Content-Length is %d", ErrInvalidBeacon, body.raw.count, body.contentLength)
+	}
+	if body.checksum != "" && body.checksum != binary.beacon.SHA256 {
+		return fmt.Errorf("%w: SHA-256 is %s, the creator announced %s", ErrInvalidBeacon, binary.beacon.SHA256, body.checksum)
+	}
+	detected, err := DetectTarget(binary.tempFilePath.Name())
+	if err != nil {
+		return fmt.Errorf("%w: not an executable: %s", ErrInvalidBeacon, err)
+	}
+	if normalizeTargetName(detected.Os) != normalizeTargetName(target.Os) || normalizeTargetName(detected.Arch) != normalizeTargetName(target.Arch) {
+		return fmt.Errorf("%w: built for %s, requested %s", ErrInvalidBeacon, detected, target)
+	}
+	return nil
+}
+
+// removeTempBinary closes and removes the temp file of a beacon that will not be installed
+func removeTempBinary(binary *TempBinary) {
+	binary.tempFilePath.Close()
+	os.Remove(binary.tempFilePath.Name())
+}
@@ -0,0 +1,68 @@
+package forge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunner_ValidateBeacon(t *testing.T) {
+	darwin, err := os.ReadFile("testFiles/ls_darwin")
+	assert.NoError(t, err, "error should be nil")
+	beacon := testELF("beacon")
+	sum := sha256.Sum256(beacon)
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		invalid bool
+	}{
+		{"an HTML error page is refused", nil, []byte("<html><body>502 Bad Gateway</body></html>"), true},
+		{"a beacon for another target is refused", nil, darwin, true},
+		{"a checksum mismatch is refused", http.Header{ChecksumHeader: {hex.EncodeToString(make([]byte, 32))}}, beacon, true},
+		{"a matching checksum is accepted", http.Header{ChecksumHeader: {hex.EncodeToString(sum[:])}}, beacon, false},
+		{"a matching Content-Digest is accepted", http.Header{"Content-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"}}, beacon, false},
+		{"a beacon without checksum is accepted", nil, beacon, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				w.Write(tt.body)
+			}))
+			defer testServer.Close()
+			outdir := t.TempDir()
+			runner := newTestRunner(t, nil, Options{CreatorUrl: testServer.URL, OutputFolder: outdir, SkipPreflight: true})
+			testFile := createTestExecutable(t, "test")
+			defer os.Remove(testFile.Name())
+
+			results, err := runner.Run(context.Background(), []string{testFile.Name()})
+			if !tt.invalid {
+				assert.NoError(t, err, "error should be nil")
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidBeacon, "error should be ErrInvalidBeacon")
+			var fileErr *FileError
+			assert.True(t, errors.As(results[0].Err, &fileErr), "error should be a FileError")
+			assert.Equal(t, OpValidate, fileErr.Op, "validate should be the failed operation")
+			assert.NoFileExists(t, filepath.Join(outdir, filepath.Base(testFile.Name())), "nothing should be installed")
+			assertNoTempFiles(t, outdir)
+		})
+	}
+
+	t.Run("a body shorter than its Content-Length is refused", func(t *testing.T) {
+		body := &beaconBody{raw: &countingReader{count: 5}, contentLength: 10}
+		err := validateBeacon(body, &TempBinary{}, Target{Os: "linux", Arch: "amd64"})
+		assert.ErrorIs(t, err, ErrInvalidBeacon, "error should be ErrInvalidBeacon")
+	})
+}